@@ -2,15 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"printer-connector/internal/agent"
 	"printer-connector/internal/config"
+	"printer-connector/internal/moonraker"
 )
 
 var version = "0.1.0"
@@ -19,17 +22,35 @@ func main() {
 	var (
 		cfgPath     string
 		logLevel    string
+		logFormat   string
 		once        bool
 		showVersion bool
+		validate    bool
+		deregister  bool
+		printSchema bool
 	)
-	flag.StringVar(&cfgPath, "config", "", "Path to config JSON (required)")
+	flag.StringVar(&cfgPath, "config", "", "Path to config file, JSON or YAML (.yaml/.yml) (required)")
 	flag.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format: text|json")
 	flag.BoolVar(&once, "once", false, "Run one iteration of each loop and exit (debug)")
-	flag.BoolVar(&showVersion, "version", false, "Show version and exit")
+	flag.BoolVar(&showVersion, "version", false, "Show version and build info, then exit")
+	flag.BoolVar(&validate, "validate", false, "Validate config and Moonraker reachability, then exit (1 = invalid config, 2 = unreachable printer)")
+	flag.BoolVar(&deregister, "deregister", false, "Deregister this connector from the cloud, then exit")
+	flag.BoolVar(&printSchema, "print-schema", false, "Print a JSON Schema for the config file, then exit")
 	flag.Parse()
 
-	if showVersion {
-		fmt.Printf("printer-connector version %s\n", version)
+	if showVersion || flag.Arg(0) == "version" {
+		fmt.Print(buildInfo())
+		os.Exit(0)
+	}
+
+	if printSchema {
+		b, err := json.MarshalIndent(config.Schema(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
 		os.Exit(0)
 	}
 
@@ -53,7 +74,19 @@ func main() {
 		os.Exit(2)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, handlerOpts)
+	default:
+		fmt.Fprintln(os.Stderr, "error: invalid --log-format (text|json)")
+		os.Exit(2)
+	}
+
+	logger := slog.New(handler).With("version", version)
 	slog.SetDefault(logger)
 
 	cfg, err := config.Load(cfgPath)
@@ -66,6 +99,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Unpaired connectors get connector_id attached by the agent after pairing.
+	if cfg.ConnectorID != "" {
+		logger = logger.With("connector_id", cfg.ConnectorID)
+		slog.SetDefault(logger)
+	}
+
+	if validate {
+		os.Exit(checkPrinters(cfg))
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -77,13 +120,25 @@ func main() {
 		cancel()
 	}()
 
-	a := agent.New(agent.Options{
+	a, err := agent.New(agent.Options{
 		ConfigPath: cfgPath,
 		Config:     cfg,
 		Logger:     logger,
 		Version:    version,
 		Once:       once,
 	})
+	if err != nil {
+		logger.Error("failed to initialize agent", "error", err)
+		os.Exit(1)
+	}
+
+	if deregister {
+		if err := a.Deregister(ctx); err != nil {
+			logger.Error("failed to deregister connector", "error", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	if err := a.Run(ctx); err != nil {
 		logger.Error("agent exited with error", "error", err)
@@ -92,3 +147,40 @@ func main() {
 
 	logger.Info("agent exited cleanly")
 }
+
+// checkPrinters pings every configured Moonraker instance and reports the
+// unreachable ones on stderr. It returns the exit code for -validate.
+func checkPrinters(cfg *config.Config) int {
+	unreachable := 0
+	for _, p := range cfg.Moonraker {
+		mc, err := moonraker.NewWithOptions(moonraker.Options{
+			BaseURL: p.BaseURL,
+			UIPort:  p.UIPort,
+			APIKey:  p.APIKey,
+
+			Timeout:             time.Duration(cfg.MoonrakerTimeoutSeconds) * time.Second,
+			DialTimeout:         time.Duration(cfg.DialTimeoutSeconds) * time.Second,
+			TLSHandshakeTimeout: time.Duration(cfg.TLSHandshakeTimeoutSeconds) * time.Second,
+
+			CACertPath:         p.CACertPath,
+			InsecureSkipVerify: p.InsecureSkipVerify,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid: printer_id=%d name=%q: %v\n", p.PrinterID, p.Name, err)
+			unreachable++
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, err = mc.QueryObjects(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unreachable: printer_id=%d name=%q base_url=%s: %v\n", p.PrinterID, p.Name, p.BaseURL, err)
+			unreachable++
+		}
+	}
+	if unreachable > 0 {
+		return 2
+	}
+	fmt.Printf("config OK (%d printers reachable)\n", len(cfg.Moonraker))
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// buildInfo describes the running binary for -version: release version, Go
+// toolchain, platform and the VCS revision stamped in by `go build`.
+func buildInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "printer-connector version %s\n", version)
+	fmt.Fprintf(&b, "go: %s\n", runtime.Version())
+	fmt.Fprintf(&b, "platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+
+	revision, built, modified := "unknown", "", false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.time":
+				built = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if modified {
+		revision += " (modified)"
+	}
+	fmt.Fprintf(&b, "revision: %s\n", revision)
+	if built != "" {
+		fmt.Fprintf(&b, "commit time: %s\n", built)
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"printer-connector/internal/cloud"
+)
+
+// adminState is the live state shown by the admin API. The loops update it
+// as they go; the admin server reads it from its own goroutines.
+type adminState struct {
+	mu              sync.Mutex
+	lastHeartbeatAt string
+	printers        map[int]*printerStatus
+}
+
+type printerStatus struct {
+	Reachable      *bool          `json:"reachable"` // null until first checked
+	LastError      string         `json:"last_error,omitempty"`
+	LastCheckedAt  string         `json:"last_checked_at,omitempty"`
+	LastSnapshotAt string         `json:"last_snapshot_at,omitempty"`
+	LastCommand    *commandStatus `json:"last_command,omitempty"`
+}
+
+type commandStatus struct {
+	ID          cloud.StringOrNumber `json:"id"`
+	Action      string               `json:"action"`
+	Status      string               `json:"status"`
+	CompletedAt string               `json:"completed_at"`
+}
+
+// printer returns printerID's entry, creating it. Callers hold s.mu.
+func (s *adminState) printer(printerID int) *printerStatus {
+	if s.printers == nil {
+		s.printers = map[int]*printerStatus{}
+	}
+	p, ok := s.printers[printerID]
+	if !ok {
+		p = &printerStatus{}
+		s.printers[printerID] = p
+	}
+	return p
+}
+
+func (s *adminState) heartbeat(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeatAt = at.UTC().Format(time.RFC3339)
+}
+
+func (s *adminState) checked(printerID int, reachable bool, lastErr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.printer(printerID)
+	p.Reachable = &reachable
+	p.LastError = lastErr
+	p.LastCheckedAt = time.Now().UTC().Format(time.RFC3339)
+}
+
+func (s *adminState) snapshotsPushed(snaps []cloud.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, snap := range snaps {
+		// RFC3339 UTC timestamps order as strings; buffered snapshots may be
+		// older than one already pushed.
+		if p := s.printer(snap.PrinterID); snap.CapturedAt > p.LastSnapshotAt {
+			p.LastSnapshotAt = snap.CapturedAt
+		}
+	}
+}
+
+func (s *adminState) commandCompleted(cmd cloud.Command, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.printer(cmd.PrinterID).LastCommand = &commandStatus{
+		ID:          cmd.ID,
+		Action:      cmd.Action,
+		Status:      status,
+		CompletedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// adminListenAddr defaults a host-less address to loopback, so the admin
+// API is only exposed to other hosts when asked for explicitly.
+func adminListenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func (a *Agent) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, a.adminStatus())
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, _ *http.Request) {
+		a.cfgMu.RLock()
+		cfg := a.cfg.Redacted()
+		a.cfgMu.RUnlock()
+		writeJSON(w, cfg)
+	})
+	return mux
+}
+
+type adminStatusResponse struct {
+	Version         string              `json:"version"`
+	ConnectorID     string              `json:"connector_id"`
+	StartedAt       string              `json:"started_at"`
+	UptimeSeconds   int64               `json:"uptime_seconds"`
+	LastHeartbeatAt string              `json:"last_heartbeat_at,omitempty"`
+	Printers        []adminPrinterEntry `json:"printers"`
+}
+
+type adminPrinterEntry struct {
+	PrinterID int    `json:"printer_id"`
+	Name      string `json:"name,omitempty"`
+	printerStatus
+}
+
+func (a *Agent) adminStatus() adminStatusResponse {
+	a.admin.mu.Lock()
+	defer a.admin.mu.Unlock()
+
+	resp := adminStatusResponse{
+		Version:         a.version,
+		ConnectorID:     a.cloud.ConnectorID(),
+		StartedAt:       a.startedAt.UTC().Format(time.RFC3339),
+		UptimeSeconds:   int64(time.Since(a.startedAt).Seconds()),
+		LastHeartbeatAt: a.admin.lastHeartbeatAt,
+		Printers:        make([]adminPrinterEntry, 0, len(a.cfg.Moonraker)),
+	}
+	for _, p := range a.cfg.Moonraker {
+		e := adminPrinterEntry{PrinterID: p.PrinterID, Name: p.Name}
+		if st, ok := a.admin.printers[p.PrinterID]; ok {
+			e.printerStatus = *st
+		}
+		resp.Printers = append(resp.Printers, e)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -3,10 +3,14 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	"printer-connector/internal/cloud"
@@ -30,26 +34,150 @@ type Agent struct {
 	version string
 	once    bool
 
+	// cfgMu guards the credential fields of cfg once the loops run; only
+	// re-pairing (see repair.go) writes them then.
+	cfgMu sync.RWMutex
+
 	cloud *cloud.Client
 	moons map[int]*moonraker.Client
 
+	// Per-printer schedules; built in Run once polling intervals are final.
+	snapSched    *schedule
+	cmdSched     *schedule
+	deferredCmds map[int][]cloud.Command
+
+	completed *commandLog
+	snapBuf   *snapshotBuffer
+	audit     *auditLog
+
+	// Last pushed snapshot per printer for DedupeSnapshots; only the
+	// snapshots loop touches it.
+	lastSnaps map[int]snapshotDigest
+
+	// Parsed SnapshotFieldAllowlist; nil pushes every field.
+	snapFields fieldTree
+
+	metrics *agentMetrics
+	health  health
+	servers sync.WaitGroup
+
+	// Printers currently receiving snapshots over a Moonraker websocket.
+	wsMu   sync.Mutex
+	wsLive map[int]bool
+
+	recovery recovery
+
+	// Filament sensor objects discovered per printer (see filamentSensors).
+	sensorsMu sync.Mutex
+	sensors   map[int][]string
+
+	// Moonraker version checks per printer (see compat.go).
+	moonrakerMu     sync.Mutex
+	moonrakerChecks map[int]moonrakerCheck
+
 	startedAt time.Time
+
+	// Live state for the admin API (see admin.go).
+	admin adminState
+
+	// Per-printer reachability for online/offline events (see events.go).
+	reach map[int]*reachState
+
+	// Recent successful Moonraker queries, reused by the heartbeat.
+	reachCache *reachCache
+
+	// Unread Moonraker announcements per printer (see announcements.go).
+	announcements map[int]announcementCount
+
+	// Moonraker host descriptions per printer (see hostinfo.go).
+	hosts map[int]*cloud.PrinterHost
+
+	// Last latest_version from the cloud that was logged as an update.
+	notifiedVersion string
+
+	// Cloud circuit breaker state last logged by the heartbeat loop.
+	circuitOpen bool
+
+	// Serializes re-pairing and rate-limits it (see repair.go).
+	repairMu   sync.Mutex
+	lastRepair time.Time
+
+	// Guards the backup state file (see backupstate.go).
+	backupMu sync.Mutex
+
+	// Backup uploads in progress, reported in the heartbeat.
+	uploads uploadTracker
 }
 
-func New(opts Options) *Agent {
+func New(opts Options) (*Agent, error) {
 	userAgent := "printer-connector/" + opts.Version
+	if opts.Config.UserAgentSuffix != "" {
+		userAgent += " " + opts.Config.UserAgentSuffix
+	}
 
-	cl := cloud.New(cloud.Options{
+	cl, err := cloud.New(cloud.Options{
 		BaseURL:         opts.Config.CloudURL,
+		BaseURLs:        opts.Config.CloudURLs,
 		ConnectorID:     opts.Config.ConnectorID,
 		ConnectorSecret: opts.Config.ConnectorSecret,
 		Logger:          opts.Logger,
 		UserAgent:       userAgent,
+		SigningKey:      opts.Config.SigningKey,
+		ClientCertPath:  opts.Config.ClientCertPath,
+		ClientKeyPath:   opts.Config.ClientKeyPath,
+		CACertPath:      opts.Config.CACertPath,
+
+		PinnedCertSHA256: opts.Config.PinnedCertSHA256,
+
+		CompressRequests: opts.Config.CompressRequests,
+		MaxAttempts:      opts.Config.CloudMaxAttempts,
+		RateLimit:        opts.Config.CloudRateLimit,
+		RateBurst:        opts.Config.CloudRateBurst,
+
+		CircuitBreakerThreshold: opts.Config.CircuitBreakerThreshold,
+		CircuitBreakerCooldown:  seconds(opts.Config.CircuitBreakerCooldownSeconds),
+
+		Timeout:             seconds(opts.Config.CloudTimeoutSeconds),
+		DialTimeout:         seconds(opts.Config.DialTimeoutSeconds),
+		TLSHandshakeTimeout: seconds(opts.Config.TLSHandshakeTimeoutSeconds),
+		UploadTimeout:       seconds(opts.Config.UploadTimeoutSeconds),
+
+		SlowRequestThreshold: time.Duration(opts.Config.SlowRequestThresholdMillis) * time.Millisecond,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("cloud client: %w", err)
+	}
 
 	moons := map[int]*moonraker.Client{}
 	for _, p := range opts.Config.Moonraker {
-		moons[p.PrinterID] = moonraker.New(p.BaseURL, p.UIPort)
+		mc, err := moonraker.NewWithOptions(moonraker.Options{
+			BaseURL: p.BaseURL,
+			UIPort:  p.UIPort,
+			APIKey:  p.APIKey,
+
+			Timeout:             seconds(opts.Config.MoonrakerTimeoutSeconds),
+			DialTimeout:         seconds(opts.Config.DialTimeoutSeconds),
+			TLSHandshakeTimeout: seconds(opts.Config.TLSHandshakeTimeoutSeconds),
+
+			CACertPath:         p.CACertPath,
+			InsecureSkipVerify: p.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("moonraker client for printer_id %d: %w", p.PrinterID, err)
+		}
+		if p.InsecureSkipVerify {
+			opts.Logger.Warn("TLS verification disabled for moonraker", "printer_id", p.PrinterID, "base_url", p.BaseURL)
+		}
+		moons[p.PrinterID] = mc
+	}
+
+	completed, err := loadCommandLog(filepath.Join(opts.Config.StateDir, "completed_commands.json"))
+	if err != nil {
+		opts.Logger.Warn("failed to load completed command log", "error", err)
+	}
+	snapBuf, err := loadSnapshotBuffer(filepath.Join(opts.Config.StateDir, "snapshot_buffer.jsonl"), opts.Config.MaxSnapshotBufferBytes)
+	if err != nil {
+		opts.Logger.Warn("failed to load snapshot buffer", "error", err)
 	}
 
 	return &Agent{
@@ -61,38 +189,105 @@ func New(opts Options) *Agent {
 		cloud:     cl,
 		moons:     moons,
 		startedAt: time.Now(),
-	}
+
+		deferredCmds:    map[int][]cloud.Command{},
+		completed:       completed,
+		snapBuf:         snapBuf,
+		audit:           openAuditLog(opts.Config.AuditLogPath, opts.Config.AuditLogMaxBytes, opts.Config.AuditLogMaxFiles, opts.Logger),
+		metrics:         newAgentMetrics(),
+		wsLive:          map[int]bool{},
+		sensors:         map[int][]string{},
+		moonrakerChecks: map[int]moonrakerCheck{},
+		reach:           map[int]*reachState{},
+		reachCache:      newReachCache(time.Duration(opts.Config.ReachabilityCacheMillis) * time.Millisecond),
+		announcements:   map[int]announcementCount{},
+		hosts:           map[int]*cloud.PrinterHost{},
+		lastSnaps:       map[int]snapshotDigest{},
+		snapFields:      newFieldTree(opts.Config.SnapshotFieldAllowlist),
+	}, nil
 }
 
 func (a *Agent) Run(ctx context.Context) error {
+	// Auxiliary servers are stopped (and waited for) whenever Run returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer a.audit.close()
+	defer moonraker.CloseIdleConnections()
+	defer a.servers.Wait()
+	defer cancel()
+
+	if a.cfg.HealthAddr != "" && !a.once {
+		a.serveHTTP(ctx, "health", a.cfg.HealthAddr, a.health.handler())
+	}
+
 	if a.cfg.PairingToken != "" {
 		if err := a.pair(ctx); err != nil {
 			return err
 		}
 	}
+	a.health.paired.Store(true)
+
+	snapTick := a.minInterval(a.snapshotInterval, time.Duration(a.cfg.PushSnapshotsSeconds)*time.Second)
+	cmdTick := a.minInterval(a.commandInterval, time.Duration(a.cfg.PollCommandsSeconds)*time.Second)
+	a.snapSched = newSchedule(snapTick)
+	a.cmdSched = newSchedule(cmdTick)
 
 	a.log.Info("connector running",
-		"connector_id", a.cfg.ConnectorID,
 		"cloud_url", a.cfg.CloudURL,
 		"printers", len(a.cfg.Moonraker),
 	)
 
 	if a.once {
 		_ = a.sendHeartbeat(ctx)
-		_ = a.pollAndExecuteCommands(ctx)
+		_ = a.pollAndExecuteCommands(ctx, ctx)
+		a.failDeferredCommands(ctx)
 		_ = a.collectAndPushSnapshots(ctx)
 		_ = a.processWebcamRequests(ctx)
 		return nil
 	}
 
+	if a.cfg.MetricsAddr != "" {
+		a.serveHTTP(ctx, "metrics", a.cfg.MetricsAddr, a.metrics.handler())
+	}
+	if a.cfg.AdminAddr != "" {
+		a.serveHTTP(ctx, "admin", adminListenAddr(a.cfg.AdminAddr), a.adminHandler())
+	}
+
 	errCh := make(chan error, 4)
 	go func() { errCh <- a.heartbeatLoop(ctx) }()
-	go func() { errCh <- a.commandsLoop(ctx) }()
-	go func() { errCh <- a.snapshotsLoop(ctx) }()
+
+	// Catch up on buffered snapshots before fresh ones; heartbeats keep
+	// going meanwhile so the connector doesn't look offline.
+	a.startupFlush(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	cmdDone := make(chan struct{})
+	go func() {
+		defer close(cmdDone)
+		errCh <- a.commandsLoop(ctx, cmdTick)
+	}()
+	go func() { errCh <- a.snapshotsLoop(ctx, snapTick) }()
 	go func() { errCh <- a.webcamLoop(ctx) }()
+	if a.cfg.UseWebsocket {
+		for _, p := range a.cfg.Moonraker {
+			go a.websocketSnapshotsLoop(ctx, p)
+		}
+	}
+	a.health.running.Store(true)
 
 	select {
 	case <-ctx.Done():
+		// Let an in-flight command finish and report its completion
+		// (bounded by ShutdownGraceSeconds) so it isn't left "running".
+		<-cmdDone
+		if a.cfg.DeregisterOnShutdown {
+			work, cancel := a.graceContext(ctx)
+			defer cancel()
+			if err := a.Deregister(work); err != nil {
+				a.log.Warn("deregistration on shutdown failed", "error", err)
+			}
+		}
 		return nil
 	case err := <-errCh:
 		if errors.Is(err, context.Canceled) {
@@ -102,41 +297,40 @@ func (a *Agent) Run(ctx context.Context) error {
 	}
 }
 
-func (a *Agent) pair(ctx context.Context) error {
-	hostname, _ := os.Hostname()
-
-	var uiPort int
-	if len(a.cfg.Moonraker) > 0 {
-		uiPort = a.cfg.Moonraker[0].UIPort
+// Deregister removes this connector from the cloud. It needs the credentials
+// from a previous pairing.
+func (a *Agent) Deregister(ctx context.Context) error {
+	id := a.cloud.ConnectorID()
+	if id == "" {
+		return errors.New("connector is not paired")
 	}
-
-	// Build printers array from moonraker config
-	printers := make([]cloud.PrinterInfo, 0, len(a.cfg.Moonraker))
-	for _, m := range a.cfg.Moonraker {
-		printers = append(printers, cloud.PrinterInfo{
-			Name:   m.Name,
-			UIPort: m.UIPort,
-		})
+	if err := a.cloud.Deregister(ctx, id); err != nil {
+		return err
 	}
+	a.log.Info("connector deregistered")
+	return nil
+}
 
-	req := cloud.RegisterRequest{
-		PairingToken: a.cfg.PairingToken,
-		SiteName:     a.cfg.SiteName,
-		Device: cloud.DeviceInfo{
-			Hostname: hostname,
-			Arch:     runtime.GOARCH,
-			OS:       runtime.GOOS,
-			Version:  a.version,
-			IP:       getLocalIP(),
-			UIPort:   uiPort,
-		},
-		Printers: printers,
-	}
+func (a *Agent) pair(ctx context.Context) error {
+	req := a.registerRequest(a.cfg.PairingToken)
 
-	a.log.Info("pairing connector (register)")
-	resp, err := a.cloud.Register(ctx, req)
-	if err != nil {
-		return err
+	// Keep trying until the cloud answers: on first boot the network (or the
+	// cloud) may not be up yet, and exiting would just make systemd restart
+	// us in a tight loop.
+	bo := util.NewBackoff(1*time.Second, 60*time.Second)
+	var resp *cloud.RegisterResponse
+	for {
+		a.log.Info("pairing connector (register)", "attempt", bo.Attempt()+1)
+		var err error
+		resp, err = a.cloud.Register(ctx, req)
+		if err == nil {
+			break
+		}
+		wait, _ := bo.Next()
+		a.log.Warn("pairing failed", "error", err, "attempt", bo.Attempt(), "retry_in", wait)
+		if err := util.SleepCtx(ctx, wait); err != nil {
+			return err
+		}
 	}
 
 	a.cfg.ConnectorID = string(resp.Connector.ID)
@@ -170,120 +364,82 @@ func (a *Agent) pair(ctx context.Context) error {
 
 	a.cloud.SetCredentials(a.cfg.ConnectorID, a.cfg.ConnectorSecret)
 	a.log.Info("paired successfully", "connector_id", a.cfg.ConnectorID)
+	a.log = a.log.With("connector_id", a.cfg.ConnectorID)
+	a.checkLatestVersion(resp.LatestVersion, resp.UpdateURL)
 	return nil
 }
 
-func (a *Agent) heartbeatLoop(ctx context.Context) error {
-	tick := time.NewTicker(time.Duration(a.cfg.HeartbeatSeconds) * time.Second)
-	defer tick.Stop()
-
-	bo := util.NewBackoff(1*time.Second, 60*time.Second)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		if err := a.sendHeartbeat(ctx); err != nil {
-			a.log.Warn("heartbeat failed", "error", err)
-			time.Sleep(bo.Next())
-		} else {
-			bo.Reset()
-		}
+// registerRequest describes this device and its printers for pairing with
+// token.
+func (a *Agent) registerRequest(token string) cloud.RegisterRequest {
+	hostname, _ := os.Hostname()
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tick.C:
-		}
+	var uiPort int
+	if len(a.cfg.Moonraker) > 0 {
+		uiPort = a.cfg.Moonraker[0].UIPort
 	}
-}
-
-func (a *Agent) commandsLoop(ctx context.Context) error {
-	tick := time.NewTicker(time.Duration(a.cfg.PollCommandsSeconds) * time.Second)
-	defer tick.Stop()
-
-	bo := util.NewBackoff(1*time.Second, 60*time.Second)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
 
-		if err := a.pollAndExecuteCommands(ctx); err != nil {
-			a.log.Warn("commands poll failed", "error", err)
-			time.Sleep(bo.Next())
-		} else {
-			bo.Reset()
-		}
+	// Build printers array from moonraker config
+	printers := make([]cloud.PrinterInfo, 0, len(a.cfg.Moonraker))
+	for _, m := range a.cfg.Moonraker {
+		printers = append(printers, cloud.PrinterInfo{
+			Name:   m.Name,
+			UIPort: m.UIPort,
+		})
+	}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tick.C:
-		}
+	return cloud.RegisterRequest{
+		PairingToken: token,
+		SiteName:     a.cfg.SiteName,
+		Device: cloud.DeviceInfo{
+			Hostname: hostname,
+			Arch:     runtime.GOARCH,
+			OS:       runtime.GOOS,
+			Version:  a.version,
+			IP:       getLocalIP(a.cfg.CloudURL),
+			UIPort:   uiPort,
+		},
+		Printers: printers,
 	}
 }
 
-func (a *Agent) snapshotsLoop(ctx context.Context) error {
-	tick := time.NewTicker(time.Duration(a.cfg.PushSnapshotsSeconds) * time.Second)
-	defer tick.Stop()
+func (a *Agent) heartbeatLoop(ctx context.Context) error {
+	return a.runLoop(ctx, "heartbeat failed", time.Duration(a.cfg.HeartbeatSeconds)*time.Second, a.sendHeartbeat)
+}
 
-	bo := util.NewBackoff(1*time.Second, 60*time.Second)
+func (a *Agent) commandsLoop(ctx context.Context, every time.Duration) error {
+	work, cancelWork := a.graceContext(ctx)
+	defer cancelWork()
+	// Deferred commands were already claimed from the cloud; report them
+	// rather than leaving them running forever.
+	defer a.failDeferredCommands(work)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	return a.runLoop(ctx, "commands poll failed", every, func(ctx context.Context) error {
+		return a.pollAndExecuteCommands(ctx, work)
+	})
+}
 
-		if err := a.collectAndPushSnapshots(ctx); err != nil {
-			a.log.Warn("snapshots push failed", "error", err)
-			time.Sleep(bo.Next())
-		} else {
-			bo.Reset()
-		}
+func (a *Agent) snapshotsLoop(ctx context.Context, every time.Duration) error {
+	return a.runLoop(ctx, "snapshots push failed", every, a.collectAndPushSnapshots)
+}
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tick.C:
-		}
+// graceContext returns a context that outlives ctx by ShutdownGraceSeconds,
+// so work already in progress when shutdown starts can still complete.
+func (a *Agent) graceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	grace := time.Duration(a.cfg.ShutdownGraceSeconds) * time.Second
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(grace, cancel)
+	})
+	return work, func() {
+		stop()
+		cancel()
 	}
 }
 
 func (a *Agent) webcamLoop(ctx context.Context) error {
 	// Poll webcam requests every 2 seconds (more frequent than snapshots for responsiveness)
-	tick := time.NewTicker(2 * time.Second)
-	defer tick.Stop()
-
-	bo := util.NewBackoff(1*time.Second, 60*time.Second)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		if err := a.processWebcamRequests(ctx); err != nil {
-			a.log.Warn("webcam requests processing failed", "error", err)
-			time.Sleep(bo.Next())
-		} else {
-			bo.Reset()
-		}
-
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tick.C:
-		}
-	}
+	return a.runLoop(ctx, "webcam requests processing failed", 2*time.Second, a.processWebcamRequests)
 }
 
 func (a *Agent) processWebcamRequests(ctx context.Context) error {
@@ -345,8 +501,14 @@ func (a *Agent) handleWebcamRequest(ctx context.Context, req cloud.WebcamRequest
 	return nil
 }
 
-// getLocalIP returns the non-loopback local IP address of the machine
-func getLocalIP() string {
+// getLocalIP returns the address of the interface used to reach cloudURL,
+// which on multi-homed hosts is the one the dashboard should link to. It
+// falls back to the first non-loopback IPv4 address.
+func getLocalIP(cloudURL string) string {
+	if ip := outboundIP(cloudURL); ip != "" {
+		return ip
+	}
+
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return ""
@@ -360,3 +522,55 @@ func getLocalIP() string {
 	}
 	return ""
 }
+
+// outboundIP asks the kernel which local address routes to the cloud host.
+// Connecting a UDP socket sends no packets, so this works offline too as
+// long as a route exists.
+func outboundIP(cloudURL string) string {
+	u, err := url.Parse(cloudURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(u.Hostname(), port), 2*time.Second)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.IP.IsLoopback() || addr.IP.IsUnspecified() {
+		return ""
+	}
+	return addr.IP.String()
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// printerLog returns the agent logger tagged with printer_id and, when one is
+// configured, printer_name.
+func (a *Agent) printerLog(printerID int) *slog.Logger {
+	if p, ok := a.printerConfig(printerID); ok && p.Name != "" {
+		return a.log.With("printer_id", printerID, "printer_name", p.Name)
+	}
+	return a.log.With("printer_id", printerID)
+}
+
+// printerConfig returns the Moonraker entry for printerID.
+func (a *Agent) printerConfig(printerID int) (config.MoonrakerPrinter, bool) {
+	for _, p := range a.cfg.Moonraker {
+		if p.PrinterID == printerID {
+			return p, true
+		}
+	}
+	return config.MoonrakerPrinter{}, false
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"printer-connector/internal/moonraker"
+)
+
+// announcementsRefresh is how often a printer's announcements are re-read
+// for the heartbeat; they change over days, not seconds.
+const announcementsRefresh = 10 * time.Minute
+
+// announcementCount is a printer's last unread announcement count. Only the
+// heartbeat loop touches it.
+type announcementCount struct {
+	unread    int
+	checkedAt time.Time
+}
+
+// unreadAnnouncements returns the number of announcements not yet dismissed
+// on the printer, refreshed every announcementsRefresh. A failed lookup
+// keeps the previous count and is retried on the next heartbeat.
+func (a *Agent) unreadAnnouncements(ctx context.Context, printerID int, mc *moonraker.Client) int {
+	last, ok := a.announcements[printerID]
+	if ok && time.Since(last.checkedAt) < announcementsRefresh {
+		return last.unread
+	}
+
+	items, err := mc.GetAnnouncements(ctx)
+	if err != nil {
+		a.printerLog(printerID).Debug("moonraker announcements failed", "error", err)
+		return last.unread
+	}
+	unread := 0
+	for _, item := range items {
+		if !item.Dismissed {
+			unread++
+		}
+	}
+	if unread > last.unread {
+		a.printerLog(printerID).Info("moonraker announcements pending", "unread", unread)
+	}
+	a.announcements[printerID] = announcementCount{unread: unread, checkedAt: time.Now()}
+	return unread
+}
@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"printer-connector/internal/cloud"
+)
+
+// auditQueueSize bounds the records waiting to be written; past it records
+// are dropped (and the drop logged) rather than holding up commands. The
+// drops are counted into an auditGapAction record, so the chain shows them.
+const auditQueueSize = 256
+
+// auditGapAction marks a gap record: Dropped records were lost between it
+// and the record before it.
+const auditGapAction = "audit_gap"
+
+// auditRecord is one line of the command audit log. PrevHash is the SHA-256
+// of the previous line (rotated files included), so removing or editing a
+// line breaks the chain.
+type auditRecord struct {
+	CommandID  cloud.StringOrNumber `json:"command_id"`
+	PrinterID  int                  `json:"printer_id"`
+	Action     string               `json:"action"`
+	Params     map[string]any       `json:"params,omitempty"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt time.Time            `json:"finished_at"`
+	Status     string               `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	Dropped    int64                `json:"dropped,omitempty"`
+	PrevHash   string               `json:"prev_hash"`
+}
+
+// auditLog appends command records to a JSON-lines file on its own
+// goroutine, so a slow disk never delays command execution. The file is
+// rotated to path.1 ... path.<maxFiles> once it would exceed maxBytes.
+type auditLog struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	log      *slog.Logger
+
+	queue   chan auditRecord
+	done    chan struct{}
+	dropped atomic.Int64 // since the last gap record
+
+	// Only the writer goroutine touches these.
+	file     *os.File
+	size     int64
+	prevHash string
+}
+
+// openAuditLog starts the writer for the audit log at path, continuing the
+// hash chain of an existing file.
+func openAuditLog(path string, maxBytes int64, maxFiles int, log *slog.Logger) *auditLog {
+	l := &auditLog{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		log:      log,
+		queue:    make(chan auditRecord, auditQueueSize),
+		done:     make(chan struct{}),
+	}
+	prev, err := lastLineHash(path)
+	if err != nil {
+		log.Warn("failed to read command audit log", "path", path, "error", err)
+	}
+	l.prevHash = prev
+	go l.run()
+	return l
+}
+
+// record queues an audit line for cmd without blocking.
+func (l *auditLog) record(cmd cloud.Command, started, finished time.Time, req cloud.CommandCompleteRequest) {
+	rec := auditRecord{
+		CommandID:  cmd.ID,
+		PrinterID:  cmd.PrinterID,
+		Action:     cmd.Action,
+		Params:     redactParams(cmd.Params),
+		StartedAt:  started.UTC(),
+		FinishedAt: finished.UTC(),
+		Status:     req.Status,
+		Error:      req.ErrorMessage,
+	}
+	// Queue the gap ahead of rec, where the drops happened.
+	if n := l.dropped.Swap(0); n > 0 {
+		select {
+		case l.queue <- gapRecord(n):
+		default:
+			l.dropped.Add(n)
+		}
+	}
+	select {
+	case l.queue <- rec:
+	default:
+		l.dropped.Add(1)
+		l.log.Warn("command audit queue full, record dropped", "command_id", cmd.ID, "action", cmd.Action)
+	}
+}
+
+// close writes out the queued records and closes the file.
+func (l *auditLog) close() {
+	close(l.queue)
+	<-l.done
+}
+
+func (l *auditLog) run() {
+	defer close(l.done)
+	for rec := range l.queue {
+		if err := l.write(rec); err != nil {
+			l.log.Warn("failed to write command audit log", "command_id", rec.CommandID, "error", err)
+		}
+	}
+	l.writeGap()
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// gapRecord stands in for n dropped records.
+func gapRecord(n int64) auditRecord {
+	now := time.Now().UTC()
+	return auditRecord{Action: auditGapAction, StartedAt: now, FinishedAt: now, Dropped: n}
+}
+
+// writeGap records drops no later record got to queue a gap for.
+func (l *auditLog) writeGap() {
+	if n := l.dropped.Swap(0); n > 0 {
+		if err := l.write(gapRecord(n)); err != nil {
+			l.log.Warn("failed to write command audit gap", "dropped", n, "error", err)
+		}
+	}
+}
+
+func (l *auditLog) write(rec auditRecord) error {
+	rec.PrevHash = l.prevHash
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.file != nil && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.file == nil {
+		if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		l.file, l.size = f, st.Size()
+	}
+
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	l.size += int64(len(line))
+	l.prevHash = lineHash(line)
+	return nil
+}
+
+// rotate shifts path.N to path.N+1 (dropping the oldest) and path to path.1.
+func (l *auditLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(bytes.TrimRight(line, "\n"))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLineHash returns the hash of the last line of path, or "" when the
+// file is missing or empty.
+func lastLineHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	if err := sc.Err(); err != nil || last == nil {
+		return "", err
+	}
+	return lineHash(last), nil
+}
+
+// redactParams copies params for the audit log: secrets are masked, query
+// strings (presigned signatures) are stripped from URLs and inline file
+// content is replaced by its size.
+func redactParams(params map[string]any) map[string]any {
+	if len(params) == 0 {
+		return nil
+	}
+	out := make(map[string]any, len(params))
+	for k, v := range params {
+		key := strings.ToLower(k)
+		s, isString := v.(string)
+		switch {
+		case strings.Contains(key, "token") || strings.Contains(key, "secret") || strings.Contains(key, "password") || strings.Contains(key, "key"):
+			out[k] = "REDACTED"
+		case isString && key == "content":
+			out[k] = fmt.Sprintf("<%d bytes>", len(s))
+		case isString && strings.Contains(key, "url"):
+			if u, err := url.Parse(s); err == nil && u.RawQuery != "" {
+				u.RawQuery = "REDACTED"
+				s = u.String()
+			}
+			out[k] = s
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
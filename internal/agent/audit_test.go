@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"printer-connector/internal/cloud"
+)
+
+func TestAuditLogRecordsGap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := newLoopTestAgent()
+	// Built by hand so the writer isn't running yet and the tiny queue fills.
+	l := &auditLog{
+		path:     path,
+		maxBytes: 1 << 20,
+		maxFiles: 1,
+		log:      a.log,
+		queue:    make(chan auditRecord, 2),
+		done:     make(chan struct{}),
+	}
+	now := time.Now()
+	rec := func(id string) {
+		l.record(cloud.Command{ID: cloud.StringOrNumber(id), PrinterID: 1, Action: "pause"}, now, now, cloud.CommandCompleteRequest{Status: "succeeded"})
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		rec(id) // 1 and 2 queue, 3-5 are dropped
+	}
+	go l.run()
+	for len(l.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rec("6")
+	rec("7")
+	l.close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []auditRecord
+	prev := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.PrevHash != prev {
+			t.Errorf("record %d: prev_hash %q; want %q", len(got)+1, r.PrevHash, prev)
+		}
+		prev = lineHash(sc.Bytes())
+		got = append(got, r)
+	}
+
+	want := []string{"1", "2", auditGapAction, "6", "7"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records; want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		name := got[i].CommandID.String()
+		if got[i].Action == auditGapAction {
+			name = auditGapAction
+		}
+		if name != w {
+			t.Errorf("record %d = %s; want %s", i+1, name, w)
+		}
+	}
+	if got[2].Dropped != 3 {
+		t.Errorf("gap dropped = %d; want 3", got[2].Dropped)
+	}
+}
@@ -0,0 +1,362 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"printer-connector/internal/backup"
+	"printer-connector/internal/cloud"
+)
+
+func (a *Agent) executeCreateBackup(ctx context.Context, cmd cloud.Command, result map[string]any) error {
+	// Extract and validate params
+	backupID, _ := cmd.Params["backup_id"].(string)
+	if backupID == "" {
+		return fmt.Errorf("missing params.backup_id")
+	}
+
+	presignedURL, _ := cmd.Params["presigned_url"].(string)
+	if presignedURL == "" {
+		return fmt.Errorf("missing params.presigned_url")
+	}
+
+	// Stream straight to the presigned URL when the cloud's storage accepts
+	// chunked uploads; avoids a temp copy of the archive on small SD cards.
+	stream, _ := cmd.Params["stream"].(bool)
+
+	opts, err := a.backupOptions(cmd, backupID)
+	if err != nil {
+		return err
+	}
+	a.logBackupStart(backupID, opts, stream)
+
+	if stream {
+		return a.streamBackup(ctx, backupID, presignedURL, opts, result)
+	}
+
+	// Create backup archive
+	started := time.Now()
+	backupResult, err := backup.Create(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	// Always cleanup temp archive after upload (or failure)
+	defer func() {
+		if err := os.Remove(backupResult.ArchivePath); err != nil {
+			a.log.Warn("failed to cleanup backup archive", "path", backupResult.ArchivePath, "error", err)
+		}
+	}()
+
+	a.log.Info("backup archive created",
+		"backup_id", backupID,
+		"size_bytes", backupResult.SizeBytes,
+		"sha256", backupResult.SHA256,
+	)
+
+	// Upload to presigned URL
+	defer a.uploads.done(backupID)
+	if err := a.cloud.UploadBackup(ctx, presignedURL, backupResult.ArchivePath, cloud.UploadOptions{
+		Progress:    a.uploads.progress(backupID),
+		ContentType: backupResult.ContentType(),
+	}); err != nil {
+		return fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	a.log.Info("backup uploaded successfully", "backup_id", backupID)
+	a.recordBackup(opts, started, result)
+
+	// Populate result
+	a.reportArchiveDetails(backupID, backupResult, result)
+	result["backup_id"] = backupID
+	result["size_bytes"] = backupResult.SizeBytes
+	result["sha256"] = backupResult.SHA256
+	result["uploaded_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	return nil
+}
+
+// executeBackup handles the on-demand "backup" action: the archive is built
+// first, then the cloud is asked for an upload URL with the archive's size
+// and hash, so no presigned URL needs to be minted ahead of time.
+func (a *Agent) executeBackup(ctx context.Context, cmd cloud.Command, result map[string]any) error {
+	name := "backup-" + cmd.ID.String()
+	opts, err := a.backupOptions(cmd, name)
+	if err != nil {
+		return err
+	}
+	a.logBackupStart(name, opts, false)
+
+	started := time.Now()
+	backupResult, err := backup.Create(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	// Always cleanup temp archive after upload (or failure)
+	defer func() {
+		if err := os.Remove(backupResult.ArchivePath); err != nil {
+			a.log.Warn("failed to cleanup backup archive", "path", backupResult.ArchivePath, "error", err)
+		}
+	}()
+
+	ticket, err := a.cloud.RequestBackupUpload(ctx, a.cloud.ConnectorID(), cloud.BackupMeta{
+		PrinterID: cmd.PrinterID,
+		Filename:  filepath.Base(backupResult.ArchivePath),
+		SizeBytes: backupResult.SizeBytes,
+		SHA256:    backupResult.SHA256,
+		Includes:  backupIncludes(opts),
+		Encrypted: backupResult.Encrypted,
+
+		IncrementalSince:  formatSince(opts.IncrementalSince),
+		SupportsMultipart: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to request backup upload: %w", err)
+	}
+
+	defer a.uploads.done(ticket.BackupID.String())
+	if err := a.cloud.UploadBackup(ctx, ticket.UploadURL, backupResult.ArchivePath, cloud.UploadOptions{
+		Multipart:   ticket.Multipart,
+		BackupID:    ticket.BackupID,
+		Progress:    a.uploads.progress(ticket.BackupID.String()),
+		ContentType: backupResult.ContentType(),
+	}); err != nil {
+		return fmt.Errorf("failed to upload backup: %w", err)
+	}
+
+	a.log.Info("backup uploaded successfully",
+		"backup_id", ticket.BackupID.String(),
+		"size_bytes", backupResult.SizeBytes,
+		"sha256", backupResult.SHA256,
+	)
+
+	a.recordBackup(opts, started, result)
+	a.reportArchiveDetails(ticket.BackupID.String(), backupResult, result)
+	result["backup_id"] = ticket.BackupID.String()
+	result["size_bytes"] = backupResult.SizeBytes
+	result["sha256"] = backupResult.SHA256
+	result["uploaded_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	return nil
+}
+
+// streamBackup pipes backup.CreateStream directly into the upload so the
+// archive never touches the disk.
+func (a *Agent) streamBackup(ctx context.Context, backupID, presignedURL string, opts backup.Options, result map[string]any) error {
+	pr, pw := io.Pipe()
+	started := time.Now()
+
+	type createResult struct {
+		res *backup.Result
+		err error
+	}
+	done := make(chan createResult, 1)
+	go func() {
+		res, err := backup.CreateStream(ctx, opts, pw)
+		pw.CloseWithError(err)
+		done <- createResult{res, err}
+	}()
+
+	uploadErr := a.cloud.UploadBackupStream(ctx, presignedURL, pr, -1, opts.ContentType())
+	// Unblock the archiver if the upload gave up early.
+	pr.CloseWithError(uploadErr)
+	created := <-done
+
+	if created.err != nil {
+		return fmt.Errorf("failed to create backup: %w", created.err)
+	}
+	if uploadErr != nil {
+		return fmt.Errorf("failed to upload backup: %w", uploadErr)
+	}
+
+	a.log.Info("backup streamed successfully",
+		"backup_id", backupID,
+		"size_bytes", created.res.SizeBytes,
+		"sha256", created.res.SHA256,
+	)
+
+	a.recordBackup(opts, started, result)
+	a.reportArchiveDetails(backupID, created.res, result)
+	result["backup_id"] = backupID
+	result["size_bytes"] = created.res.SizeBytes
+	result["sha256"] = created.res.SHA256
+	result["uploaded_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	return nil
+}
+
+// backupOptions builds backup.Options from command params. The archive is
+// written to StateDir/<name>.tar.gz (or .zip; plus .enc when encrypted).
+func (a *Agent) backupOptions(cmd cloud.Command, name string) (backup.Options, error) {
+	// Get printer_data root (default: /usr/data/printer_data for K1, ~/printer_data for others)
+	printerDataRoot := "/usr/data/printer_data"
+	if home := os.Getenv("HOME"); home != "" && home != "/root" {
+		printerDataRoot = home + "/printer_data"
+	}
+	if override, ok := cmd.Params["printer_data_root"].(string); ok && override != "" {
+		printerDataRoot = override
+		// Expand tilde if present - use K1 path for root user, otherwise HOME
+		if strings.HasPrefix(printerDataRoot, "~/") {
+			home := os.Getenv("HOME")
+			if home == "/root" {
+				// K1 Max: use /usr/data/printer_data even if ~/printer_data is specified
+				printerDataRoot = filepath.Join("/usr/data", printerDataRoot[2:])
+			} else if home != "" {
+				printerDataRoot = filepath.Join(home, printerDataRoot[2:])
+			}
+		}
+	}
+
+	// Parse include options (default all to false)
+	includeMap, _ := cmd.Params["include"].(map[string]any)
+	includeConfig, _ := includeMap["config"].(bool)
+	includeDatabase, _ := includeMap["database"].(bool)
+	includeGcodes, _ := includeMap["gcodes"].(bool)
+	includeLogs, _ := includeMap["logs"].(bool)
+	extraDirs := stringSliceParam(cmd.Params, "extra_dirs")
+
+	// Ensure at least one directory is included
+	if !includeConfig && !includeDatabase && !includeGcodes && !includeLogs && len(extraDirs) == 0 {
+		return backup.Options{}, fmt.Errorf("no directories selected for backup")
+	}
+
+	key, err := a.cfg.BackupKey()
+	if err != nil {
+		return backup.Options{}, err
+	}
+
+	formatParam, _ := cmd.Params["format"].(string)
+	format, err := backup.ParseFormat(formatParam)
+	if err != nil {
+		return backup.Options{}, err
+	}
+
+	// Create output path in state directory
+	ext := format.Extension()
+	if key != nil {
+		ext += ".enc"
+	}
+	outputPath := filepath.Join(a.cfg.StateDir, name+ext)
+
+	// Ensure state directory exists
+	if err := os.MkdirAll(a.cfg.StateDir, 0755); err != nil {
+		return backup.Options{}, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	opts := backup.Options{
+		PrinterDataRoot: printerDataRoot,
+		IncludeConfig:   includeConfig,
+		IncludeDatabase: includeDatabase,
+		IncludeGcodes:   includeGcodes,
+		IncludeLogs:     includeLogs,
+		OutputPath:      outputPath,
+		MaxSizeBytes:    10 << 30, // 10GB limit
+		IncludePatterns: stringSliceParam(cmd.Params, "include_patterns"),
+		ExcludePatterns: stringSliceParam(cmd.Params, "exclude_patterns"),
+
+		MaxFiles:         100000,
+		MaxFileSizeBytes: 2 << 30,  // 2GB per file
+		MinFreeBytes:     64 << 20, // keep 64MB free on the SD card
+		EncryptionKey:    key,
+		Format:           format,
+		ExtraDirs:        extraDirs,
+	}
+	if _, err := opts.Dirs(); err != nil {
+		return backup.Options{}, err
+	}
+
+	// Incremental: only files changed since the last backup of the same
+	// directories; the first one falls back to a full backup.
+	if incremental, _ := cmd.Params["incremental"].(bool); incremental {
+		if since := a.lastBackupTime(opts); !since.IsZero() {
+			opts.IncrementalSince = since
+		} else {
+			a.log.Info("no previous backup of these directories, creating a full backup")
+		}
+	}
+	return opts, nil
+}
+
+// reportArchiveDetails adds the file count and optional details (encryption,
+// links the archiver left out because they were broken or pointed outside
+// printer_data) to the command result, warning about skipped links.
+func (a *Agent) reportArchiveDetails(backupID string, res *backup.Result, result map[string]any) {
+	result["file_count"] = res.FileCount
+	if res.Encrypted {
+		result["encrypted"] = true
+		result["encryption_scheme"] = res.EncryptionScheme
+	}
+	if len(res.SkippedSymlinks) == 0 {
+		return
+	}
+	a.log.Warn("backup skipped symlinks", "backup_id", backupID, "links", res.SkippedSymlinks)
+	result["skipped_symlinks"] = res.SkippedSymlinks
+}
+
+func (a *Agent) logBackupStart(backupID string, opts backup.Options, stream bool) {
+	a.log.Info("creating backup",
+		"backup_id", backupID,
+		"printer_data_root", opts.PrinterDataRoot,
+		"include_config", opts.IncludeConfig,
+		"include_database", opts.IncludeDatabase,
+		"include_gcodes", opts.IncludeGcodes,
+		"include_logs", opts.IncludeLogs,
+		"extra_dirs", opts.ExtraDirs,
+		"format", opts.Format,
+		"incremental_since", formatSince(opts.IncrementalSince),
+		"stream", stream,
+	)
+}
+
+// formatSince renders an incremental base time, "" for full backups.
+func formatSince(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// uploadTracker records backup uploads in progress for the heartbeat. The
+// zero value is ready to use.
+type uploadTracker struct {
+	mu      sync.Mutex
+	uploads map[string]cloud.UploadProgress
+}
+
+// progress returns a cloud.UploadOptions.Progress callback for backupID.
+func (t *uploadTracker) progress(backupID string) func(sent, total int64) {
+	return func(sent, total int64) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.uploads == nil {
+			t.uploads = map[string]cloud.UploadProgress{}
+		}
+		t.uploads[backupID] = cloud.UploadProgress{BackupID: backupID, BytesSent: sent, TotalBytes: total}
+	}
+}
+
+func (t *uploadTracker) done(backupID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.uploads, backupID)
+}
+
+// list returns the uploads in progress ordered by backup ID.
+func (t *uploadTracker) list() []cloud.UploadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]cloud.UploadProgress, 0, len(t.uploads))
+	for _, u := range t.uploads {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].BackupID < out[j].BackupID })
+	return out
+}
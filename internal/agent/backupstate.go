@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"printer-connector/internal/backup"
+)
+
+// backupStateFile, under StateDir, records when the last successful backup
+// of each include set started, as the base for incremental backups.
+const backupStateFile = "backup_state.json"
+
+type backupState struct {
+	LastBackup map[string]time.Time `json:"last_backup"`
+}
+
+// backupIncludes lists the directories selected in opts, sorted.
+func backupIncludes(opts backup.Options) []string {
+	includes, _ := opts.Dirs() // already validated by backupOptions
+	sort.Strings(includes)
+	return includes
+}
+
+// lastBackupTime returns when the last successful backup of the same
+// directories started, or the zero time if there is none.
+func (a *Agent) lastBackupTime(opts backup.Options) time.Time {
+	a.backupMu.Lock()
+	defer a.backupMu.Unlock()
+	return a.loadBackupState().LastBackup[strings.Join(backupIncludes(opts), ",")]
+}
+
+// recordBackup stores started as the base for the next incremental backup
+// of the same directories.
+func (a *Agent) recordBackup(opts backup.Options, started time.Time, result map[string]any) {
+	if since := formatSince(opts.IncrementalSince); since != "" {
+		result["incremental_since"] = since
+	}
+
+	a.backupMu.Lock()
+	defer a.backupMu.Unlock()
+	st := a.loadBackupState()
+	st.LastBackup[strings.Join(backupIncludes(opts), ",")] = started.UTC()
+	if err := a.saveBackupState(st); err != nil {
+		a.log.Warn("failed to persist backup state", "error", err)
+	}
+}
+
+// loadBackupState reads the state file; a missing or corrupt one yields an
+// empty state, which just makes the next incremental a full backup.
+func (a *Agent) loadBackupState() backupState {
+	st := backupState{LastBackup: map[string]time.Time{}}
+	b, err := os.ReadFile(filepath.Join(a.cfg.StateDir, backupStateFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			a.log.Warn("failed to read backup state", "error", err)
+		}
+		return st
+	}
+	if err := json.Unmarshal(b, &st); err != nil || st.LastBackup == nil {
+		return backupState{LastBackup: map[string]time.Time{}}
+	}
+	return st
+}
+
+func (a *Agent) saveBackupState(st backupState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(a.cfg.StateDir, backupStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -3,112 +3,658 @@ package agent
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"math"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"printer-connector/internal/backup"
 	"printer-connector/internal/cloud"
 	"printer-connector/internal/moonraker"
+	"printer-connector/internal/util"
 )
 
-func (a *Agent) pollAndExecuteCommands(ctx context.Context) error {
-	cmds, err := a.cloud.GetCommands(ctx, a.cfg.ConnectorID, 20)
+// pollAndExecuteCommands fetches pending commands using ctx and executes them
+// using work. On shutdown ctx is cancelled first: the command in progress
+// keeps running on work (which outlives ctx by the grace period) and is
+// reported, while commands that haven't started are failed back to the cloud
+// so they don't stay stuck as running.
+func (a *Agent) pollAndExecuteCommands(ctx, work context.Context) error {
+	cmds, err := a.fetchCommands(ctx)
 	if err != nil {
 		return err
 	}
-	if len(cmds) == 0 {
-		return nil
+
+	// Commands for different printers run concurrently (bounded by
+	// MaxConcurrentCommands); commands for the same printer stay serial and
+	// in order so conflicting actions can't interleave.
+	due := a.dueCommands(cmds)
+	var order []int
+	byPrinter := map[int][]cloud.Command{}
+	for _, cmd := range due {
+		if _, ok := byPrinter[cmd.PrinterID]; !ok {
+			order = append(order, cmd.PrinterID)
+		}
+		byPrinter[cmd.PrinterID] = append(byPrinter[cmd.PrinterID], cmd)
+	}
+
+	// A lone command is reported as soon as it finishes; with several, the
+	// completions are collected and sent in batches (see completionBatch).
+	var batch *completionBatch
+	if len(due) > 1 {
+		batch = newCompletionBatch(func(items []cloud.BatchCompletion) {
+			a.flushCompletions(work, items)
+		})
+	}
+
+	limit := a.cfg.MaxConcurrentCommands
+	if limit <= 0 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, printerID := range order {
+		wg.Add(1)
+		go func(queue []cloud.Command) {
+			defer wg.Done()
+			for _, cmd := range queue {
+				if ctx.Err() != nil {
+					now := time.Now()
+					req := a.complete(work, cmd, cloud.CommandCompleteRequest{
+						Status:       "failed",
+						ErrorMessage: "connector shutting down",
+						Result:       map[string]any{"action": cmd.Action},
+					}, batch)
+					a.audit.record(cmd, now, now, req)
+					continue
+				}
+				sem <- struct{}{}
+				start := time.Now()
+				req := a.executeCommand(work, cmd, batch)
+				a.audit.record(cmd, start, time.Now(), req)
+				<-sem
+			}
+		}(byPrinter[printerID])
+	}
+	wg.Wait()
+	if batch != nil {
+		batch.flush()
+	}
+
+	return nil
+}
+
+// Commands are fetched CommandsFetchLimit at a time, draining up to
+// maxPagesPerPoll pages per poll so a backlog isn't held to one page per tick.
+const maxPagesPerPoll = 10
+
+// fetchCommands follows next_cursor until the cloud has no more pending
+// commands or maxPagesPerPoll pages were read. The cloud marks fetched
+// commands as running, so a failure on a later page still returns the
+// earlier ones.
+func (a *Agent) fetchCommands(ctx context.Context) ([]cloud.Command, error) {
+	limit := a.cfg.CommandsFetchLimit
+	var cmds []cloud.Command
+	cursor := ""
+	for {
+		page, err := a.cloud.GetCommands(ctx, a.cloud.ConnectorID(), limit, cursor)
+		if err != nil {
+			if len(cmds) == 0 {
+				return nil, err
+			}
+			a.log.Warn("failed to fetch next commands page", "fetched", len(cmds), "error", err)
+			return cmds, nil
+		}
+		cmds = append(cmds, page.Commands...)
+		if page.NextCursor == "" || page.NextCursor == cursor || len(page.Commands) == 0 || len(cmds) >= maxPagesPerPoll*limit {
+			return cmds, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// dueCommands returns the commands that should run now, in order. Commands
+// for printers whose command interval hasn't elapsed yet are held back in
+// a.deferredCmds (the cloud already marked them running, so they can't be
+// left for the next poll) and released once the printer is due.
+func (a *Agent) dueCommands(cmds []cloud.Command) []cloud.Command {
+	now := time.Now()
+	due := map[int]bool{}
+	var out []cloud.Command
+	for _, p := range a.cfg.Moonraker {
+		if !a.cmdSched.due(p.PrinterID, now, a.commandInterval(p)) {
+			continue
+		}
+		due[p.PrinterID] = true
+		out = append(out, a.deferredCmds[p.PrinterID]...)
+		delete(a.deferredCmds, p.PrinterID)
 	}
 
 	for _, cmd := range cmds {
-		start := time.Now()
-		a.log.Info("executing command", "command_id", cmd.ID, "printer_id", cmd.PrinterID, "action", cmd.Action)
+		if a.moons[cmd.PrinterID] != nil && !due[cmd.PrinterID] {
+			a.printerLog(cmd.PrinterID).Debug("deferring command until printer is due", "command_id", cmd.ID)
+			a.deferredCmds[cmd.PrinterID] = append(a.deferredCmds[cmd.PrinterID], cmd)
+			continue
+		}
+		out = append(out, cmd)
+	}
+	return out
+}
 
-		mc := a.moons[cmd.PrinterID]
-		if mc == nil {
-			_ = a.cloud.CompleteCommand(ctx, cmd.ID, cloud.CommandCompleteRequest{
+// failDeferredCommands completes every command still held in a.deferredCmds
+// as failed, for shutdown. ctx should outlive the loop's context by the
+// grace period so the completions can still be sent.
+func (a *Agent) failDeferredCommands(ctx context.Context) {
+	for printerID, cmds := range a.deferredCmds {
+		for _, cmd := range cmds {
+			now := time.Now()
+			req := a.complete(ctx, cmd, cloud.CommandCompleteRequest{
 				Status:       "failed",
-				ErrorMessage: fmt.Sprintf("unknown printer_id %d", cmd.PrinterID),
-				Result:       map[string]any{"printer_id": cmd.PrinterID},
-			})
-			continue
+				ErrorMessage: "connector shutting down",
+				Result:       map[string]any{"action": cmd.Action},
+			}, nil)
+			a.audit.record(cmd, now, now, req)
 		}
+		delete(a.deferredCmds, printerID)
+	}
+}
 
-		var execErr error
-		result := map[string]any{"action": cmd.Action}
-
-		switch cmd.Action {
-		case "pause":
-			execErr = mc.Pause(ctx)
-		case "resume":
-			execErr = mc.Resume(ctx)
-		case "cancel":
-			execErr = mc.Cancel(ctx)
-		case "start_print":
-			filename, _ := cmd.Params["filename"].(string)
-			if filename == "" {
-				execErr = fmt.Errorf("missing params.filename for start_print")
-			} else {
-				result["filename"] = filename
-				execErr = mc.StartPrint(ctx, filename)
-			}
-		case "homing":
-			// Optional axes parameter: {"axes": ["X", "Y"]} or empty for all
-			var axes []string
-			if axesParam, ok := cmd.Params["axes"].([]any); ok {
-				for _, a := range axesParam {
-					if axisStr, ok := a.(string); ok {
-						axes = append(axes, axisStr)
-					}
-				}
-			}
-			if len(axes) > 0 {
-				result["axes"] = axes
-			} else {
-				result["axes"] = "all"
-			}
-			execErr = mc.Home(ctx, axes...)
-		case "upload_file":
-			execErr = a.executeUploadFile(ctx, mc, cmd, result)
-		case "delete_file":
-			execErr = a.executeDeleteFile(ctx, mc, cmd, result)
-		case "sync_files":
-			execErr = a.executeSyncFiles(ctx, mc, cmd, result)
-		case "import_history":
-			execErr = a.executeImportHistory(ctx, mc, cmd, result)
-		case "create_backup":
-			execErr = a.executeCreateBackup(ctx, cmd, result)
-		default:
-			execErr = fmt.Errorf("unsupported action: %s", cmd.Action)
-		}
-
-		if execErr != nil {
-			a.log.Warn("command failed", "command_id", cmd.ID, "error", execErr)
-			_ = a.cloud.CompleteCommand(ctx, cmd.ID, cloud.CommandCompleteRequest{
+// executeCommand runs cmd and reports its outcome, into batch when non-nil.
+// It returns the completion that was reported.
+func (a *Agent) executeCommand(ctx context.Context, cmd cloud.Command, batch *completionBatch) cloud.CommandCompleteRequest {
+	log := a.printerLog(cmd.PrinterID)
+	if prev, ok := a.completed.lookup(cmd.ID); ok {
+		// Already executed (e.g. our completion POST was lost). Re-report
+		// the original outcome instead of running the command again.
+		log.Warn("skipping already completed command", "command_id", cmd.ID, "action", cmd.Action, "status", prev.Status)
+		req := cloud.CommandCompleteRequest{
+			Status: prev.Status,
+			Result: map[string]any{"action": cmd.Action, "duplicate": true},
+		}
+		if prev.Status == "failed" {
+			req.ErrorMessage = "command already completed as failed"
+		}
+		a.report(ctx, cmd, req, batch)
+		return req
+	}
+
+	if p, ok := a.printerConfig(cmd.PrinterID); ok && !p.ActionAllowed(cmd.Action) {
+		log.Warn("command rejected by allowed_actions", "command_id", cmd.ID, "action", cmd.Action)
+		return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
+			Status:       "failed",
+			ErrorMessage: fmt.Sprintf("action not permitted on this printer: %s", cmd.Action),
+			Result:       map[string]any{"action": cmd.Action, "allowed_actions": p.AllowedActions},
+		}, batch)
+	}
+
+	// Best effort: a failed ack only delays the running state on the
+	// dashboard, so it never holds up the command itself.
+	if err := a.cloud.AckCommand(ctx, cmd.ID); err != nil {
+		log.Warn("failed to acknowledge command", "command_id", cmd.ID, "error", err)
+	}
+
+	start := time.Now()
+	log.Info("executing command", "command_id", cmd.ID, "action", cmd.Action)
+
+	mc := a.moons[cmd.PrinterID]
+	if mc == nil {
+		return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
+			Status:       "failed",
+			ErrorMessage: fmt.Sprintf("unknown printer_id %d", cmd.PrinterID),
+			Result:       map[string]any{"printer_id": cmd.PrinterID},
+		}, batch)
+	}
+
+	// Stopping a printer must never wait on, or be refused by, the check.
+	if !safetyActions[cmd.Action] {
+		if err := a.checkMoonraker(ctx, cmd.PrinterID, mc); err != nil {
+			log.Warn("command rejected by moonraker version check", "command_id", cmd.ID, "error", err)
+			return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
 				Status:       "failed",
-				ErrorMessage: execErr.Error(),
-				Result:       result,
-			})
-			continue
+				ErrorMessage: err.Error(),
+				Result:       map[string]any{"action": cmd.Action, "moonraker_version": a.moonrakerVersion(cmd.PrinterID)},
+			}, batch)
 		}
+	}
 
-		if payload, snapErr := mc.QueryObjects(ctx); snapErr == nil {
+	result := map[string]any{"action": cmd.Action}
+
+	// Dry run: prove the command reached the right printer without touching it.
+	if a.cfg.DryRun {
+		log.Info("dry run: command not sent to printer",
+			"command_id", cmd.ID,
+			"action", cmd.Action,
+			"params", cmd.Params,
+		)
+		result["dry_run"] = true
+		return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
+			Status: "succeeded",
+			Result: result,
+		}, batch)
+	}
+
+	// Bound the action itself; completion is still reported on ctx so a
+	// timed-out command doesn't leave the cloud waiting.
+	timeout := a.actionTimeout(cmd)
+	actCtx, cancel := context.WithTimeout(ctx, timeout)
+	execErr := checkPrintState(actCtx, mc, cmd, result)
+	if execErr == nil {
+		execErr = a.runAction(actCtx, mc, cmd, result)
+	}
+	// The printer's state (or whether it answers at all) may have changed.
+	a.reachCache.invalidate(cmd.PrinterID)
+	if execErr != nil && errors.Is(actCtx.Err(), context.DeadlineExceeded) {
+		execErr = fmt.Errorf("command timed out after %s", timeout)
+	}
+	cancel()
+
+	if execErr != nil {
+		log.Warn("command failed", "command_id", cmd.ID, "error", execErr)
+		return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
+			Status:       "failed",
+			ErrorMessage: execErr.Error(),
+			Result:       result,
+		}, batch)
+	}
+
+	// get_status already returns the printer state in the result.
+	if cmd.Action != "get_status" {
+		if payload, _, snapErr := a.querySnapshot(ctx, cmd.PrinterID, mc); snapErr == nil {
 			result["post_snapshot"] = "captured"
 			_ = a.pushSingleSnapshot(ctx, cmd.PrinterID, payload)
 		} else {
 			result["post_snapshot_error"] = snapErr.Error()
 		}
+	}
 
-		a.log.Info("command succeeded", "command_id", cmd.ID, "duration_ms", time.Since(start).Milliseconds())
-		_ = a.cloud.CompleteCommand(ctx, cmd.ID, cloud.CommandCompleteRequest{
-			Status: "succeeded",
-			Result: result,
-		})
+	log.Info("command succeeded", "command_id", cmd.ID, "duration_ms", time.Since(start).Milliseconds())
+	return a.complete(ctx, cmd, cloud.CommandCompleteRequest{
+		Status: "succeeded",
+		Result: result,
+	}, batch)
+}
+
+// runAction dispatches cmd to the matching Moonraker call, filling result
+// with action-specific details.
+func (a *Agent) runAction(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	var execErr error
+
+	switch cmd.Action {
+	case "pause":
+		execErr = mc.Pause(ctx)
+	case "resume":
+		execErr = mc.Resume(ctx)
+	case "cancel":
+		execErr = executeCancel(ctx, mc, cmd, result)
+	case "emergency_stop":
+		execErr = mc.EmergencyStop(ctx)
+		if execErr == nil {
+			result["halted"] = true
+		}
+	case "firmware_restart":
+		execErr = a.executeRestart(ctx, mc, cmd, result, mc.FirmwareRestart)
+	case "restart":
+		execErr = a.executeRestart(ctx, mc, cmd, result, mc.HostRestart)
+	case "start_print":
+		filename, _ := cmd.Params["filename"].(string)
+		if filename == "" {
+			execErr = fmt.Errorf("missing params.filename for start_print")
+		} else {
+			result["filename"] = filename
+			execErr = mc.StartPrint(ctx, filename)
+		}
+	case "homing":
+		// Optional axes parameter: {"axes": ["X", "Y"]} or empty for all
+		var axes []string
+		if axesParam, ok := cmd.Params["axes"].([]any); ok {
+			for _, a := range axesParam {
+				if axisStr, ok := a.(string); ok {
+					axes = append(axes, axisStr)
+				}
+			}
+		}
+		if len(axes) > 0 {
+			result["axes"] = axes
+		} else {
+			result["axes"] = "all"
+		}
+		execErr = mc.Home(ctx, axes...)
+	case "home":
+		axes, _ := cmd.Params["axes"].(string)
+		if axes == "" {
+			result["axes"] = "all"
+		} else {
+			result["axes"] = axes
+		}
+		execErr = mc.HomeAxes(ctx, axes)
+	case "move":
+		execErr = a.executeMove(ctx, mc, cmd, result)
+	case "set_temperature":
+		heater, _ := cmd.Params["heater"].(string)
+		target, ok := cmd.Params["target"].(float64)
+		if heater == "" || !ok {
+			execErr = fmt.Errorf("missing params.heater or params.target for set_temperature")
+		} else {
+			result["heater"] = heater
+			result["target"] = target
+			execErr = mc.SetTemperature(ctx, heater, target)
+		}
+	case "power_on":
+		execErr = executePower(ctx, mc, cmd, result, true)
+	case "power_off":
+		execErr = executePower(ctx, mc, cmd, result, false)
+	case "set_pin":
+		execErr = a.executeSetPin(ctx, mc, cmd, result)
+	case "run_gcode":
+		script, _ := cmd.Params["script"].(string)
+		if strings.TrimSpace(script) == "" {
+			execErr = fmt.Errorf("missing params.script for run_gcode")
+		} else if execErr = a.checkGcodeAllowed(script); execErr == nil {
+			result["script"] = script
+			execErr = mc.RunGcode(ctx, script)
+		}
+	case "upload_file":
+		execErr = a.executeUploadFile(ctx, mc, cmd, result)
+	case "upload_and_print":
+		execErr = a.executeUploadAndPrint(ctx, mc, cmd, result)
+	case "delete_file":
+		execErr = a.executeDeleteFile(ctx, mc, cmd, result)
+	case "sync_files":
+		execErr = a.executeSyncFiles(ctx, mc, cmd, result)
+	case "list_files":
+		execErr = a.executeListFiles(ctx, mc, cmd, result)
+	case "capture_image":
+		execErr = a.executeCaptureImage(ctx, mc, cmd, result)
+	case "get_status":
+		execErr = executeGetStatus(ctx, mc, result)
+	case "enqueue":
+		execErr = a.executeEnqueue(ctx, mc, cmd, result)
+	case "list_queue":
+		var queue []moonraker.QueueItem
+		if queue, execErr = mc.ListQueue(ctx); execErr == nil {
+			result["queue"] = queue
+			result["count"] = len(queue)
+		}
+	case "clear_queue":
+		execErr = mc.ClearQueue(ctx)
+	case "get_history":
+		execErr = a.executeGetHistory(ctx, mc, cmd, result)
+	case "import_history":
+		execErr = a.executeImportHistory(ctx, mc, cmd, result)
+	case "create_backup":
+		execErr = a.executeCreateBackup(ctx, cmd, result)
+	case "backup":
+		execErr = a.executeBackup(ctx, cmd, result)
+	default:
+		execErr = fmt.Errorf("unsupported action: %s", cmd.Action)
+	}
+
+	return execErr
+}
+
+// complete records cmd as executed and reports the outcome to the cloud,
+// returning req. The command is recorded first so that a lost completion
+// POST doesn't cause it to be re-run when the cloud hands it out again.
+func (a *Agent) complete(ctx context.Context, cmd cloud.Command, req cloud.CommandCompleteRequest, batch *completionBatch) cloud.CommandCompleteRequest {
+	a.metrics.commandsExecuted.Inc(cmd.Action, req.Status)
+	a.admin.commandCompleted(cmd, req.Status)
+	if err := a.completed.record(cmd.ID, req.Status); err != nil {
+		a.log.Warn("failed to persist completed command", "command_id", cmd.ID, "error", err)
+	}
+	a.report(ctx, cmd, req, batch)
+	return req
+}
+
+// report sends a completion to the cloud, or queues it on batch.
+func (a *Agent) report(ctx context.Context, cmd cloud.Command, req cloud.CommandCompleteRequest, batch *completionBatch) {
+	if batch != nil {
+		batch.add(cloud.BatchCompletion{CommandID: cmd.ID, CommandCompleteRequest: req})
+		return
+	}
+	if err := a.cloud.CompleteCommand(ctx, cmd.ID, req); err != nil {
+		a.log.Warn("failed to report command completion", "command_id", cmd.ID, "error", err)
+	}
+}
+
+// Collected completions are sent once completionBatchSize have piled up or
+// completionFlushDelay after the first, whichever comes sooner, so one slow
+// command doesn't hold back the results of the rest of the poll.
+const (
+	completionBatchSize  = 20
+	completionFlushDelay = 500 * time.Millisecond
+)
+
+// completionBatch collects the completions of one poll; commands for
+// different printers add to it concurrently.
+type completionBatch struct {
+	send func([]cloud.BatchCompletion)
+
+	mu    sync.Mutex
+	items []cloud.BatchCompletion
+	timer *time.Timer
+
+	// sendMu keeps one send in flight, so a final flush waits for a timed
+	// one still running.
+	sendMu sync.Mutex
+}
+
+func newCompletionBatch(send func([]cloud.BatchCompletion)) *completionBatch {
+	return &completionBatch{send: send}
+}
+
+func (b *completionBatch) add(c cloud.BatchCompletion) {
+	b.mu.Lock()
+	b.items = append(b.items, c)
+	full := len(b.items) >= completionBatchSize
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(completionFlushDelay, b.flush)
+	}
+	b.mu.Unlock()
+	if full {
+		b.flush()
+	}
+}
+
+// flush sends whatever has been collected so far.
+func (b *completionBatch) flush() {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	b.mu.Lock()
+	items := b.items
+	b.items = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(items) > 0 {
+		b.send(items)
+	}
+}
+
+func (a *Agent) flushCompletions(ctx context.Context, items []cloud.BatchCompletion) {
+	if err := a.cloud.CompleteCommands(ctx, items); err != nil {
+		a.log.Warn("failed to report command completions", "count", len(items), "error", err)
+	}
+}
+
+// cancelCleanup is run after a cancel with params.turn_off_heaters, one
+// step at a time so the result shows how far it got.
+var cancelCleanup = []string{"TURN_OFF_HEATERS", "M84"}
+
+// executeCancel cancels the print and, with params.turn_off_heaters, then
+// turns off the heaters and motors. result.post_cancel lists the cleanup
+// steps that ran; if one fails the command fails with result.cancelled set,
+// since the print itself did stop.
+func executeCancel(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	if err := mc.Cancel(ctx); err != nil {
+		return err
+	}
+	if off, _ := cmd.Params["turn_off_heaters"].(bool); !off {
+		return nil
+	}
+	result["cancelled"] = true
+	ran := []string{}
+	result["post_cancel"] = ran
+	for _, gcode := range cancelCleanup {
+		if err := mc.RunGcode(ctx, gcode); err != nil {
+			return fmt.Errorf("print cancelled but %s failed: %w", gcode, err)
+		}
+		ran = append(ran, gcode)
+		result["post_cancel"] = ran
+	}
+	return nil
+}
+
+func (a *Agent) executeMove(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	coord := func(key string) *float64 {
+		if v, ok := cmd.Params[key].(float64); ok {
+			result[key] = v
+			return &v
+		}
+		return nil
+	}
+	x, y, z := coord("x"), coord("y"), coord("z")
+	feedrate, _ := cmd.Params["feedrate"].(float64)
+
+	// Never jog the toolhead underneath a running job
+	state, err := mc.PrintState(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check print state: %w", err)
+	}
+	if state == "printing" {
+		return fmt.Errorf("cannot move toolhead while printer is printing")
+	}
+
+	return mc.MoveToolhead(ctx, x, y, z, feedrate)
+}
+
+// executeSetPin sets params.pin to params.value (0-1), if the printer's
+// allowed_pins permits it.
+func (a *Agent) executeSetPin(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	pin, _ := cmd.Params["pin"].(string)
+	value, ok := cmd.Params["value"].(float64)
+	if pin == "" || !ok {
+		return fmt.Errorf("missing params.pin or params.value for set_pin")
+	}
+	if p, ok := a.printerConfig(cmd.PrinterID); ok && !p.PinAllowed(pin) {
+		result["allowed_pins"] = p.AllowedPins
+		return fmt.Errorf("pin not permitted on this printer: %s", pin)
+	}
+	result["pin"] = pin
+	result["value"] = value
+	return mc.SetOutputPin(ctx, pin, value)
+}
+
+// executePower switches the Moonraker power device params.device. The
+// device must be one Moonraker has configured; result has the device and
+// its status before the switch.
+func executePower(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any, on bool) error {
+	device, _ := cmd.Params["device"].(string)
+	if device == "" {
+		return fmt.Errorf("missing params.device for %s", cmd.Action)
+	}
+	devices, err := mc.ListPowerDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list power devices: %w", err)
+	}
+	names := make([]string, 0, len(devices))
+	var found *moonraker.PowerDevice
+	for i, d := range devices {
+		names = append(names, d.Device)
+		if d.Device == device {
+			found = &devices[i]
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("unknown power device %q (available: %s)", device, strings.Join(names, ", "))
+	}
+
+	result["device"] = device
+	result["previous_status"] = found.Status
+	return mc.SetPower(ctx, device, on)
+}
+
+// actionTimeout bounds the execution of cmd. Printer actions get
+// CommandTimeoutSeconds; actions that move whole files or archives get
+// UploadTimeoutSeconds instead, and a restart that waits for Klippy gets
+// its wait on top.
+func (a *Agent) actionTimeout(cmd cloud.Command) time.Duration {
+	timeout := time.Duration(a.cfg.CommandTimeoutSeconds) * time.Second
+	switch cmd.Action {
+	case "upload_and_print", "create_backup", "backup":
+		if upload := time.Duration(a.cfg.UploadTimeoutSeconds) * time.Second; upload > timeout {
+			return upload
+		}
+	case "restart", "firmware_restart":
+		if wait, ok := restartWait(cmd); ok {
+			return timeout + wait
+		}
+	}
+	return timeout
+}
+
+// restartWait returns how long executeRestart waits for Klippy after a
+// restart, and false when params.wait_ready isn't set.
+func restartWait(cmd cloud.Command) (time.Duration, bool) {
+	if wait, _ := cmd.Params["wait_ready"].(bool); !wait {
+		return 0, false
+	}
+	if v, ok := cmd.Params["wait_seconds"].(float64); ok && v > 0 {
+		return time.Duration(v * float64(time.Second)), true
+	}
+	return 20 * time.Second, true
+}
+
+// restartPollInterval is how often executeRestart checks whether Klippy is
+// back after a restart.
+const restartPollInterval = time.Second
+
+// executeRestart runs restart and, with params.wait_ready, polls
+// /printer/info until Klippy reports "ready" or params.wait_seconds
+// (default 20) pass. Not coming back in time isn't an error: the state it
+// was last seen in is in the result either way.
+func (a *Agent) executeRestart(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any, restart func(context.Context) error) error {
+	if err := restart(ctx); err != nil {
+		return err
+	}
+	waitFor, ok := restartWait(cmd)
+	if !ok {
+		return nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, waitFor)
+	defer cancel()
+
+	// Klippy drops off and Moonraker may answer with errors until it's back,
+	// so only the last successful reading counts.
+	var last *moonraker.PrinterInfo
+	for {
+		if info, err := mc.PrinterInfo(waitCtx); err == nil {
+			last = info
+			if info.State == "ready" {
+				break
+			}
+		}
+		if util.SleepCtx(waitCtx, restartPollInterval) != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
+	result["ready"] = last != nil && last.State == "ready"
+	if last != nil {
+		result["state"] = last.State
+		if last.StateMessage != "" {
+			result["state_message"] = last.StateMessage
+		}
+	}
 	return nil
 }
 
@@ -141,20 +687,71 @@ func (a *Agent) executeUploadFile(ctx context.Context, mc *moonraker.Client, cmd
 	return nil
 }
 
-func (a *Agent) executeDeleteFile(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+// executeUploadAndPrint downloads params.url, streams it into Moonraker as
+// params.filename, then starts the print.
+func (a *Agent) executeUploadAndPrint(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	fileURL, _ := cmd.Params["url"].(string)
+	if fileURL == "" {
+		return fmt.Errorf("missing params.url for upload_and_print")
+	}
 	filename, _ := cmd.Params["filename"].(string)
-	if filename == "" {
-		return fmt.Errorf("missing params.filename for delete_file")
+	if err := moonraker.ValidateGcodeFilename(filename); err != nil {
+		return err
 	}
 
+	body, size, err := a.cloud.DownloadFile(ctx, fileURL)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer body.Close()
+	if size > moonraker.MaxGcodeBytes {
+		return fmt.Errorf("file is %d bytes, limit is %d", size, int64(moonraker.MaxGcodeBytes))
+	}
+
+	counted := &countingReader{r: body}
+	if err := mc.UploadGcode(ctx, filename, counted); err != nil {
+		return fmt.Errorf("failed to upload file to moonraker: %w", err)
+	}
 	result["filename"] = filename
+	result["size"] = counted.n
+	a.log.Info("file uploaded", "command_id", cmd.ID, "filename", filename, "size", counted.n)
+
+	if err := mc.StartPrint(ctx, filename); err != nil {
+		return fmt.Errorf("failed to start print: %w", err)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (a *Agent) executeDeleteFile(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	p, _ := cmd.Params["path"].(string)
+	if p == "" {
+		// Older clouds send params.filename.
+		p, _ = cmd.Params["filename"].(string)
+	}
+	if p == "" {
+		return fmt.Errorf("missing params.path for delete_file")
+	}
+
+	result["path"] = p
 
 	// Delete from Moonraker
-	if err := mc.DeleteFile(ctx, filename); err != nil {
+	if err := mc.DeleteGcode(ctx, p); err != nil {
 		return fmt.Errorf("failed to delete file from moonraker: %w", err)
 	}
+	result["deleted"] = true
 
-	a.log.Info("file deleted", "command_id", cmd.ID, "filename", filename)
+	a.log.Info("file deleted", "command_id", cmd.ID, "path", p)
 	return nil
 }
 
@@ -172,133 +769,196 @@ func (a *Agent) executeSyncFiles(ctx context.Context, mc *moonraker.Client, cmd
 	return nil
 }
 
-func (a *Agent) executeImportHistory(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
-	// Get limit from params, default to 50
-	limit := 50
-	if limitParam, ok := cmd.Params["limit"].(float64); ok {
-		limit = int(limitParam)
+// executeCaptureImage grabs a webcam frame and PUTs it to params.presigned_url.
+func (a *Agent) executeCaptureImage(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	presignedURL, _ := cmd.Params["presigned_url"].(string)
+	if presignedURL == "" {
+		return fmt.Errorf("missing params.presigned_url for capture_image")
 	}
 
-	// Fetch history from Moonraker
-	history, err := mc.GetHistory(ctx, limit)
+	var (
+		data []byte
+		err  error
+	)
+	if p, ok := a.printerConfig(cmd.PrinterID); ok && p.WebcamSnapshotURL != "" {
+		data, err = mc.CaptureWebcamSnapshot(ctx, p.WebcamSnapshotURL)
+	} else {
+		data, _, err = mc.GetWebcamSnapshot(ctx)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to fetch history from moonraker: %w", err)
+		return fmt.Errorf("failed to capture image: %w", err)
 	}
 
-	result["history"] = history
-
-	// Count jobs if available in response
-	if historyResult, ok := history["result"].(map[string]any); ok {
-		if jobs, ok := historyResult["jobs"].([]any); ok {
-			result["count"] = len(jobs)
-			a.log.Info("history imported", "command_id", cmd.ID, "count", len(jobs))
-		}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("webcam returned %s, not an image", contentType)
+	}
+	if err := a.cloud.UploadImage(ctx, presignedURL, data, contentType); err != nil {
+		return fmt.Errorf("failed to upload image: %w", err)
 	}
 
+	result["size_bytes"] = len(data)
+	result["content_type"] = contentType
+	result["captured_at"] = time.Now().UTC().Format(time.RFC3339)
+	a.log.Info("image captured", "command_id", cmd.ID, "size_bytes", len(data))
 	return nil
 }
 
-func (a *Agent) executeCreateBackup(ctx context.Context, cmd cloud.Command, result map[string]any) error {
-	// Extract and validate params
-	backupID, _ := cmd.Params["backup_id"].(string)
-	if backupID == "" {
-		return fmt.Errorf("missing params.backup_id")
+// executeGetStatus returns the current printer state plus derived progress
+// fields, for on-demand refreshes between snapshots.
+func executeGetStatus(ctx context.Context, mc *moonraker.Client, result map[string]any) error {
+	raw, err := mc.QueryObjects(ctx)
+	if err != nil {
+		return err
 	}
+	status, ok := moonraker.ExtractStatus(raw)
+	if !ok {
+		return fmt.Errorf("unexpected moonraker response")
+	}
+	result["status"] = status
+
+	sd, _ := status["virtual_sdcard"].(map[string]any)
+	ps, _ := status["print_stats"].(map[string]any)
+	progress, _ := sd["progress"].(float64)
+	printDuration, _ := ps["print_duration"].(float64)
+	state, _ := ps["state"].(string)
+
+	result["state"] = state
+	result["progress_percent"] = math.Round(progress*1000) / 10
+	// Linear estimate from time spent so far; only meaningful mid-print.
+	if (state == "printing" || state == "paused") && progress > 0 && progress < 1 {
+		result["eta_seconds"] = int64(printDuration/progress - printDuration)
+	}
+	return nil
+}
 
-	presignedURL, _ := cmd.Params["presigned_url"].(string)
-	if presignedURL == "" {
-		return fmt.Errorf("missing params.presigned_url")
-	}
-
-	// Get printer_data root (default: /usr/data/printer_data for K1, ~/printer_data for others)
-	printerDataRoot := "/usr/data/printer_data"
-	if home := os.Getenv("HOME"); home != "" && home != "/root" {
-		printerDataRoot = home + "/printer_data"
-	}
-	if override, ok := cmd.Params["printer_data_root"].(string); ok && override != "" {
-		printerDataRoot = override
-		// Expand tilde if present - use K1 path for root user, otherwise HOME
-		if strings.HasPrefix(printerDataRoot, "~/") {
-			home := os.Getenv("HOME")
-			if home == "/root" {
-				// K1 Max: use /usr/data/printer_data even if ~/printer_data is specified
-				printerDataRoot = filepath.Join("/usr/data", printerDataRoot[2:])
-			} else if home != "" {
-				printerDataRoot = filepath.Join(home, printerDataRoot[2:])
-			}
+// executeEnqueue adds params.filenames to the job queue in order. On failure
+// result.enqueued lists the files that made it in before the error.
+func (a *Agent) executeEnqueue(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	filenames := stringSliceParam(cmd.Params, "filenames")
+	if len(filenames) == 0 {
+		return fmt.Errorf("missing params.filenames for enqueue")
+	}
+
+	enqueued := []string{}
+	result["enqueued"] = enqueued
+	for _, f := range filenames {
+		if err := mc.EnqueueJob(ctx, f); err != nil {
+			return fmt.Errorf("failed to enqueue %s: %w", f, err)
 		}
+		enqueued = append(enqueued, f)
+		result["enqueued"] = enqueued
 	}
 
-	// Parse include options (default all to false)
-	includeMap, _ := cmd.Params["include"].(map[string]any)
-	includeConfig, _ := includeMap["config"].(bool)
-	includeDatabase, _ := includeMap["database"].(bool)
-	includeGcodes, _ := includeMap["gcodes"].(bool)
-	includeLogs, _ := includeMap["logs"].(bool)
+	a.log.Info("jobs enqueued", "command_id", cmd.ID, "count", len(enqueued))
+	return nil
+}
+
+// defaultListFilesLimit caps list_files results when params.limit is absent.
+const defaultListFilesLimit = 100
 
-	// Ensure at least one directory is included
-	if !includeConfig && !includeDatabase && !includeGcodes && !includeLogs {
-		return fmt.Errorf("no directories selected for backup")
+func (a *Agent) executeListFiles(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	limit := defaultListFilesLimit
+	if limitParam, ok := cmd.Params["limit"].(float64); ok && limitParam > 0 {
+		limit = int(limitParam)
 	}
 
-	// Create output path in state directory
-	outputPath := filepath.Join(a.cfg.StateDir, backupID+".tar.gz")
+	files, err := mc.ListGcodes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list files from moonraker: %w", err)
+	}
 
-	// Ensure state directory exists
-	if err := os.MkdirAll(a.cfg.StateDir, 0755); err != nil {
-		return fmt.Errorf("failed to create state directory: %w", err)
+	result["total"] = len(files)
+	if len(files) > limit {
+		files = files[:limit]
 	}
+	result["files"] = files
+	result["count"] = len(files)
 
-	a.log.Info("creating backup",
-		"backup_id", backupID,
-		"printer_data_root", printerDataRoot,
-		"include_config", includeConfig,
-		"include_database", includeDatabase,
-		"include_gcodes", includeGcodes,
-		"include_logs", includeLogs,
-	)
+	a.log.Info("files listed", "command_id", cmd.ID, "count", len(files), "total", result["total"])
+	return nil
+}
 
-	// Create backup archive
-	opts := backup.Options{
-		PrinterDataRoot: printerDataRoot,
-		IncludeConfig:   includeConfig,
-		IncludeDatabase: includeDatabase,
-		IncludeGcodes:   includeGcodes,
-		IncludeLogs:     includeLogs,
-		OutputPath:      outputPath,
-		MaxSizeBytes:    10 << 30, // 10GB limit
+func (a *Agent) executeGetHistory(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	limit := 50
+	if limitParam, ok := cmd.Params["limit"].(float64); ok {
+		limit = int(limitParam)
 	}
 
-	backupResult, err := backup.Create(opts)
+	jobs, err := mc.GetHistory(ctx, limit)
 	if err != nil {
-		return fmt.Errorf("failed to create backup: %w", err)
+		return fmt.Errorf("failed to fetch history from moonraker: %w", err)
 	}
 
-	// Always cleanup temp archive after upload (or failure)
-	defer func() {
-		if err := os.Remove(backupResult.ArchivePath); err != nil {
-			a.log.Warn("failed to cleanup backup archive", "path", backupResult.ArchivePath, "error", err)
-		}
-	}()
+	result["jobs"] = jobs
+	result["count"] = len(jobs)
+	return nil
+}
 
-	a.log.Info("backup archive created",
-		"backup_id", backupID,
-		"size_bytes", backupResult.SizeBytes,
-		"sha256", backupResult.SHA256,
-	)
+func (a *Agent) executeImportHistory(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	// Get limit from params, default to 50
+	limit := 50
+	if limitParam, ok := cmd.Params["limit"].(float64); ok {
+		limit = int(limitParam)
+	}
 
-	// Upload to presigned URL
-	if err := a.cloud.UploadBackup(ctx, presignedURL, backupResult.ArchivePath); err != nil {
-		return fmt.Errorf("failed to upload backup: %w", err)
+	// Fetch history from Moonraker
+	history, err := mc.GetHistoryRaw(ctx, limit)
+	if err != nil {
+		return fmt.Errorf("failed to fetch history from moonraker: %w", err)
 	}
 
-	a.log.Info("backup uploaded successfully", "backup_id", backupID)
+	result["history"] = history
 
-	// Populate result
-	result["backup_id"] = backupID
-	result["size_bytes"] = backupResult.SizeBytes
-	result["sha256"] = backupResult.SHA256
-	result["uploaded_at"] = time.Now().UTC().Format(time.RFC3339)
+	// Count jobs if available in response
+	if historyResult, ok := history["result"].(map[string]any); ok {
+		if jobs, ok := historyResult["jobs"].([]any); ok {
+			result["count"] = len(jobs)
+			a.log.Info("history imported", "command_id", cmd.ID, "count", len(jobs))
+		}
+	}
 
 	return nil
 }
+
+// checkGcodeAllowed enforces cfg.AllowedGcodePrefixes against every command
+// in script, so a permitted first line can't smuggle in others.
+func (a *Agent) checkGcodeAllowed(script string) error {
+	if len(a.cfg.AllowedGcodePrefixes) == 0 {
+		return nil
+	}
+	for _, line := range strings.Split(script, "\n") {
+		// Strip gcode comments
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		allowed := false
+		for _, prefix := range a.cfg.AllowedGcodePrefixes {
+			if strings.EqualFold(fields[0], prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("gcode command %q is not allowed by allowed_gcode_prefixes", fields[0])
+		}
+	}
+	return nil
+}
+
+// stringSliceParam reads a JSON array of strings from params, ignoring
+// non-string elements.
+func stringSliceParam(params map[string]any, key string) []string {
+	raw, _ := params[key].([]any)
+	var out []string
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/config"
+)
+
+func TestActionTimeout(t *testing.T) {
+	a := &Agent{cfg: &config.Config{CommandTimeoutSeconds: 30, UploadTimeoutSeconds: 1800}}
+
+	tests := []struct {
+		action string
+		params map[string]any
+		want   time.Duration
+	}{
+		{action: "pause", want: 30 * time.Second},
+		{action: "emergency_stop", want: 30 * time.Second},
+		{action: "upload_and_print", want: 30 * time.Minute},
+		{action: "create_backup", want: 30 * time.Minute},
+		{action: "backup", want: 30 * time.Minute},
+		{action: "restart", want: 30 * time.Second},
+		{action: "restart", params: map[string]any{"wait_ready": true}, want: 50 * time.Second},
+		{action: "firmware_restart", params: map[string]any{"wait_ready": true, "wait_seconds": 90.0}, want: 120 * time.Second},
+	}
+	for _, tt := range tests {
+		got := a.actionTimeout(cloud.Command{Action: tt.action, Params: tt.params})
+		if got != tt.want {
+			t.Errorf("actionTimeout(%s, %v) = %s; want %s", tt.action, tt.params, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"printer-connector/internal/moonraker"
+)
+
+// moonrakerRecheckInterval is how long a refused printer stays refused
+// before its version is looked up again, so upgrading Moonraker doesn't
+// need a connector restart.
+const moonrakerRecheckInterval = 5 * time.Minute
+
+// safetyActions run on a printer even when it is refused for its Moonraker
+// version: they only ever stop it.
+var safetyActions = map[string]bool{
+	"emergency_stop": true,
+	"cancel":         true,
+	"pause":          true,
+	"power_off":      true,
+}
+
+// moonrakerCheck is the cached outcome of a printer's version check.
+type moonrakerCheck struct {
+	version   string
+	refused   error // non-nil when RequireMoonrakerVersion rejects the printer
+	checkedAt time.Time
+}
+
+// checkMoonraker looks up the printer's Moonraker version on first contact
+// and compares it with MinMoonrakerVersion. The result is cached once
+// Moonraker has answered, a refusal only for moonrakerRecheckInterval; a
+// failed lookup is retried on the next call and doesn't change the
+// outcome. The returned error is non-nil only when the printer is refused.
+func (a *Agent) checkMoonraker(ctx context.Context, printerID int, mc *moonraker.Client) error {
+	a.moonrakerMu.Lock()
+	cached, ok := a.moonrakerChecks[printerID]
+	a.moonrakerMu.Unlock()
+	if ok && (cached.refused == nil || time.Since(cached.checkedAt) < moonrakerRecheckInterval) {
+		return cached.refused
+	}
+
+	log := a.printerLog(printerID)
+	info, err := mc.ServerInfo(ctx)
+	if err != nil {
+		log.Debug("moonraker server info failed", "error", err)
+		return cached.refused
+	}
+
+	check := moonrakerCheck{version: info.MoonrakerVersion, checkedAt: time.Now()}
+	supported, err := moonraker.VersionAtLeast(info.MoonrakerVersion, a.cfg.MinMoonrakerVersion)
+	switch {
+	case err != nil:
+		// Development builds may report something unparseable; let them through.
+		log.Warn("unrecognized moonraker version", "moonraker_version", info.MoonrakerVersion, "error", err)
+	case !supported && a.cfg.RequireMoonrakerVersion:
+		check.refused = fmt.Errorf("moonraker %s is older than the minimum supported %s", info.MoonrakerVersion, a.cfg.MinMoonrakerVersion)
+		log.Error("refusing printer with unsupported moonraker version",
+			"moonraker_version", info.MoonrakerVersion,
+			"min_version", a.cfg.MinMoonrakerVersion,
+		)
+	case !supported:
+		log.Warn("moonraker version older than supported, snapshots may be incomplete",
+			"moonraker_version", info.MoonrakerVersion,
+			"min_version", a.cfg.MinMoonrakerVersion,
+		)
+	case cached.refused != nil:
+		log.Info("moonraker upgraded, printer no longer refused", "moonraker_version", info.MoonrakerVersion)
+	default:
+		log.Info("moonraker version", "moonraker_version", info.MoonrakerVersion, "api_version", info.APIVersionString)
+	}
+
+	a.moonrakerMu.Lock()
+	a.moonrakerChecks[printerID] = check
+	a.moonrakerMu.Unlock()
+	return check.refused
+}
+
+// moonrakerVersion returns the printer's Moonraker version once checked.
+func (a *Agent) moonrakerVersion(printerID int) string {
+	a.moonrakerMu.Lock()
+	defer a.moonrakerMu.Unlock()
+	return a.moonrakerChecks[printerID].version
+}
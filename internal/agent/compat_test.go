@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"printer-connector/internal/config"
+	"printer-connector/internal/moonraker"
+)
+
+func TestCheckMoonrakerRechecksRefusal(t *testing.T) {
+	var version atomic.Value
+	version.Store("v0.7.1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"result": {"klippy_state": "ready", "moonraker_version": %q}}`, version.Load())
+	}))
+	defer srv.Close()
+
+	a := newLoopTestAgent()
+	a.cfg = &config.Config{MinMoonrakerVersion: "v0.8.0", RequireMoonrakerVersion: true}
+	a.moonrakerChecks = map[int]moonrakerCheck{}
+	mc := moonraker.New(srv.URL, 0)
+	ctx := context.Background()
+
+	if err := a.checkMoonraker(ctx, 1, mc); err == nil {
+		t.Fatal("v0.7.1 accepted; want refused")
+	}
+
+	// An upgrade is only noticed once the refusal is due for a re-check.
+	version.Store("v0.9.0")
+	if err := a.checkMoonraker(ctx, 1, mc); err == nil {
+		t.Fatal("refusal re-checked before moonrakerRecheckInterval")
+	}
+	a.moonrakerMu.Lock()
+	check := a.moonrakerChecks[1]
+	check.checkedAt = time.Now().Add(-moonrakerRecheckInterval)
+	a.moonrakerChecks[1] = check
+	a.moonrakerMu.Unlock()
+
+	if err := a.checkMoonraker(ctx, 1, mc); err != nil {
+		t.Fatalf("upgraded moonraker still refused: %v", err)
+	}
+	if v := a.moonrakerVersion(1); v != "v0.9.0" {
+		t.Errorf("moonrakerVersion = %q; want v0.9.0", v)
+	}
+}
+
+func TestSafetyActions(t *testing.T) {
+	for _, action := range []string{"emergency_stop", "cancel", "pause", "power_off"} {
+		if !safetyActions[action] {
+			t.Errorf("%s is not a safety action", action)
+		}
+	}
+	for _, action := range []string{"start_print", "power_on", "run_gcode", "resume"} {
+		if safetyActions[action] {
+			t.Errorf("%s must not bypass the moonraker version check", action)
+		}
+	}
+}
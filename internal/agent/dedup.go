@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"printer-connector/internal/cloud"
+)
+
+// completedTTL is how long a completed command ID is remembered.
+const completedTTL = 24 * time.Hour
+
+type completedCommand struct {
+	Status      string    `json:"status"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// commandLog is a small persistent record of command IDs that have already
+// been executed, so a command redelivered by the cloud (or seen again after
+// a restart) is not run twice.
+type commandLog struct {
+	mu      sync.Mutex
+	path    string
+	entries map[cloud.StringOrNumber]completedCommand
+}
+
+// loadCommandLog reads the log at path. A missing file yields an empty log;
+// a corrupt one is discarded so it can't wedge command execution.
+func loadCommandLog(path string) (*commandLog, error) {
+	l := &commandLog{path: path, entries: map[cloud.StringOrNumber]completedCommand{}}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	if err := json.Unmarshal(b, &l.entries); err != nil {
+		l.entries = map[cloud.StringOrNumber]completedCommand{}
+		return l, err
+	}
+	l.prune(time.Now())
+	return l, nil
+}
+
+func (l *commandLog) lookup(id cloud.StringOrNumber) (completedCommand, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[id]
+	return e, ok
+}
+
+// record marks id as completed with status and persists the log.
+func (l *commandLog) record(id cloud.StringOrNumber, status string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.entries[id] = completedCommand{Status: status, CompletedAt: now}
+	l.prune(now)
+	return l.save()
+}
+
+func (l *commandLog) prune(now time.Time) {
+	for id, e := range l.entries {
+		if now.Sub(e.CompletedAt) > completedTTL {
+			delete(l.entries, id)
+		}
+	}
+}
+
+// save writes the log atomically (temp + rename), like config.SaveAtomic.
+func (l *commandLog) save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(l.entries)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"printer-connector/internal/cloud"
+)
+
+// reachState debounces a printer's reachability. Only the heartbeat loop
+// touches it.
+type reachState struct {
+	reachable bool // last state reported (or the baseline)
+
+	// A differing observation starts pending; it becomes an event once it
+	// has held for ReachabilityStableSeconds.
+	pending      bool
+	pendingSince time.Time
+}
+
+// trackReachability sends an online/offline event once a printer's
+// reachability has changed and stayed changed for the configured stable
+// duration. The first observation is the baseline and sends nothing.
+func (a *Agent) trackReachability(ctx context.Context, printerID int, reachable bool) {
+	st, ok := a.reach[printerID]
+	if !ok {
+		a.reach[printerID] = &reachState{reachable: reachable}
+		return
+	}
+	if reachable == st.reachable {
+		st.pending = false
+		return
+	}
+
+	now := time.Now()
+	if !st.pending {
+		st.pending, st.pendingSince = true, now
+	}
+	if now.Sub(st.pendingSince) < time.Duration(a.cfg.ReachabilityStableSeconds)*time.Second {
+		return
+	}
+
+	ev := cloud.Event{
+		PrinterID: printerID,
+		Type:      cloud.EventOffline,
+		Timestamp: st.pendingSince.UTC().Format(time.RFC3339),
+	}
+	if reachable {
+		ev.Type = cloud.EventOnline
+	}
+	log := a.printerLog(printerID)
+	// Stay pending on failure so the event is retried next heartbeat.
+	if err := a.cloud.PostEvent(ctx, ev); err != nil {
+		log.Warn("failed to send printer event", "type", ev.Type, "error", err)
+		return
+	}
+	log.Info("printer "+ev.Type, "since", ev.Timestamp)
+	st.reachable, st.pending = reachable, false
+}
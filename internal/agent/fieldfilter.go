@@ -0,0 +1,73 @@
+package agent
+
+import "strings"
+
+// fieldTree is a parsed SnapshotFieldAllowlist: each allowed key maps to
+// the allowed paths below it, or to nil when all of it is allowed.
+type fieldTree map[string]fieldTree
+
+// newFieldTree parses dot paths. It returns nil (no filtering) for none.
+func newFieldTree(paths []string) fieldTree {
+	if len(paths) == 0 {
+		return nil
+	}
+	t := fieldTree{}
+	for _, p := range paths {
+		node := t
+		parts := strings.Split(p, ".")
+		for i, part := range parts {
+			child, seen := node[part]
+			if seen && child == nil {
+				break // a shorter path already allows all of it
+			}
+			if i == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if child == nil {
+				child = fieldTree{}
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return t
+}
+
+// prune returns a copy of m holding only the allowed paths. m is not
+// modified, though values allowed whole are shared with it.
+func (t fieldTree) prune(m map[string]any) map[string]any {
+	out := make(map[string]any, len(t))
+	for k, sub := range t {
+		v, ok := m[k]
+		if !ok {
+			continue
+		}
+		if sub == nil {
+			out[k] = v
+			continue
+		}
+		if pv, ok := sub.pruneValue(v); ok {
+			out[k] = pv
+		}
+	}
+	return out
+}
+
+// pruneValue applies t to a map, or to each element of an array. Scalars
+// have nothing below them to allow and are dropped.
+func (t fieldTree) pruneValue(v any) (any, bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		return t.prune(v), true
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, e := range v {
+			if pe, ok := t.pruneValue(e); ok {
+				out = append(out, pe)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldTreePrune(t *testing.T) {
+	status := map[string]any{
+		"print_stats": map[string]any{
+			"state":    "printing",
+			"filename": "benchy.gcode",
+			"info":     map[string]any{"current_layer": 3, "total_layer": 120},
+		},
+		"extruder": map[string]any{"temperature": 210.5, "target": 215.0},
+		"toolhead": map[string]any{"position": []any{1.0, 2.0, 3.0, 4.0}},
+		"mmu": map[string]any{
+			"gates": []any{
+				map[string]any{"material": "PLA", "color": "red", "temp": 200},
+				map[string]any{"material": "PETG", "color": "blue", "temp": 240},
+				"unknown",
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  map[string]any
+	}{
+		{
+			name:  "whole objects",
+			paths: []string{"extruder", "toolhead"},
+			want: map[string]any{
+				"extruder": map[string]any{"temperature": 210.5, "target": 215.0},
+				"toolhead": map[string]any{"position": []any{1.0, 2.0, 3.0, 4.0}},
+			},
+		},
+		{
+			name:  "nested paths",
+			paths: []string{"print_stats.state", "print_stats.info.current_layer", "extruder.target"},
+			want: map[string]any{
+				"print_stats": map[string]any{"state": "printing", "info": map[string]any{"current_layer": 3}},
+				"extruder":    map[string]any{"target": 215.0},
+			},
+		},
+		{
+			name:  "shorter path wins",
+			paths: []string{"print_stats.info.total_layer", "print_stats.info"},
+			want: map[string]any{
+				"print_stats": map[string]any{"info": map[string]any{"current_layer": 3, "total_layer": 120}},
+			},
+		},
+		{
+			name:  "shorter path first",
+			paths: []string{"print_stats.info", "print_stats.info.total_layer"},
+			want: map[string]any{
+				"print_stats": map[string]any{"info": map[string]any{"current_layer": 3, "total_layer": 120}},
+			},
+		},
+		{
+			name:  "array of objects",
+			paths: []string{"mmu.gates.material"},
+			want: map[string]any{
+				"mmu": map[string]any{"gates": []any{
+					map[string]any{"material": "PLA"},
+					map[string]any{"material": "PETG"},
+				}},
+			},
+		},
+		{
+			name:  "array of scalars under a path",
+			paths: []string{"toolhead.position.x"},
+			want:  map[string]any{"toolhead": map[string]any{"position": []any{}}},
+		},
+		{
+			name:  "path through a scalar",
+			paths: []string{"print_stats.state.foo"},
+			want:  map[string]any{"print_stats": map[string]any{}},
+		},
+		{
+			name:  "missing keys",
+			paths: []string{"heater_bed", "print_stats.missing"},
+			want:  map[string]any{"print_stats": map[string]any{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newFieldTree(tt.paths).prune(status)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prune() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldTreeDoesNotModifyInput(t *testing.T) {
+	in := map[string]any{
+		"print_stats": map[string]any{"state": "printing", "filename": "benchy.gcode"},
+		"gates":       []any{map[string]any{"material": "PLA", "color": "red"}},
+	}
+	newFieldTree([]string{"print_stats.state", "gates.material"}).prune(in)
+
+	want := map[string]any{
+		"print_stats": map[string]any{"state": "printing", "filename": "benchy.gcode"},
+		"gates":       []any{map[string]any{"material": "PLA", "color": "red"}},
+	}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("prune modified its input: %v", in)
+	}
+}
+
+func TestNewFieldTreeEmpty(t *testing.T) {
+	if tree := newFieldTree(nil); tree != nil {
+		t.Errorf("newFieldTree(nil) = %v; want nil", tree)
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// health tracks liveness/readiness for the optional probe server.
+type health struct {
+	running     atomic.Bool // agent loops have been started
+	paired      atomic.Bool // connector has credentials
+	heartbeatOK atomic.Bool // at least one heartbeat has succeeded
+}
+
+func (h *health) ready() bool {
+	return h.paired.Load() && h.heartbeatOK.Load()
+}
+
+func (h *health) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeProbe(w, h.running.Load())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		writeProbe(w, h.ready())
+	})
+	return mux
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
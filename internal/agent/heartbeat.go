@@ -3,27 +3,115 @@ package agent
 import (
 	"context"
 	"time"
+	"unicode/utf8"
 
 	"printer-connector/internal/cloud"
+	"printer-connector/internal/moonraker"
 )
 
+// maxHeartbeatErrorLen bounds last_error so a verbose failure can't bloat
+// every heartbeat.
+const maxHeartbeatErrorLen = 256
+
 func (a *Agent) sendHeartbeat(ctx context.Context) error {
 	hb := cloud.HeartbeatRequest{}
 	hb.Status.UptimeSeconds = int64(time.Since(a.startedAt).Seconds())
 	hb.Status.Version = a.version
+	if a.cfg.ReportSystemMetrics {
+		hb.Status.System = a.systemStatus()
+	}
 
 	for _, p := range a.cfg.Moonraker {
-		reachable := false
-		mc := a.moons[p.PrinterID]
-		if mc != nil {
-			_, err := mc.QueryObjects(ctx)
-			reachable = (err == nil)
+		hp := cloud.HeartbeatPrinter{PrinterID: p.PrinterID, Name: p.Name}
+		if mc := a.moons[p.PrinterID]; mc != nil {
+			err := a.probePrinter(ctx, p.PrinterID, mc, &hp)
+			hp.Reachable = err == nil
+			if err != nil {
+				hp.LastError = truncate(err.Error(), maxHeartbeatErrorLen)
+				a.printerLog(p.PrinterID).Debug("printer unreachable", "error", err)
+			} else if err := a.checkMoonraker(ctx, p.PrinterID, mc); err != nil {
+				hp.LastError = truncate(err.Error(), maxHeartbeatErrorLen)
+			}
+			hp.MoonrakerVersion = a.moonrakerVersion(p.PrinterID)
+			hp.Host = a.printerHost(ctx, p.PrinterID, mc, hp.Reachable)
+			if hp.Reachable && a.cfg.ReportAnnouncements {
+				hp.UnreadAnnouncements = a.unreadAnnouncements(ctx, p.PrinterID, mc)
+			}
 		}
-		hb.Printers = append(hb.Printers, cloud.HeartbeatPrinter{
-			PrinterID: p.PrinterID,
-			Reachable: reachable,
-		})
+		a.metrics.setReachable(p.PrinterID, hp.Reachable)
+		a.admin.checked(p.PrinterID, hp.Reachable, hp.LastError)
+		a.trackReachability(ctx, p.PrinterID, hp.Reachable)
+		hb.Printers = append(hb.Printers, hp)
+	}
+	hb.Uploads = a.uploads.list()
+
+	a.logCircuitState()
+	resp, err := a.cloud.Heartbeat(ctx, hb)
+	if err != nil {
+		return err
+	}
+	a.metrics.heartbeatsSent.Inc()
+	a.health.heartbeatOK.Store(true)
+	a.admin.heartbeat(time.Now())
+	a.checkLatestVersion(resp.LatestVersion, resp.UpdateURL)
+	return nil
+}
+
+// probePrinter checks that the printer answers, reusing a query from the
+// last ReachabilityCacheMillis if there was one, and records the latency.
+func (a *Agent) probePrinter(ctx context.Context, printerID int, mc *moonraker.Client, hp *cloud.HeartbeatPrinter) error {
+	if latency, ok := a.reachCache.recent(printerID); ok {
+		hp.LatencyMillis = int(latency.Milliseconds())
+		return nil
+	}
+	start := time.Now()
+	_, err := mc.QueryObjects(ctx)
+	latency := time.Since(start)
+	hp.LatencyMillis = int(latency.Milliseconds())
+	if err == nil {
+		a.reachCache.ok(printerID, latency)
+	}
+	return err
+}
+
+// logCircuitState logs when the cloud circuit breaker opens or closes, as
+// seen from the heartbeat loop.
+func (a *Agent) logCircuitState() {
+	open := a.cloud.CircuitOpen()
+	if open == a.circuitOpen {
+		return
+	}
+	a.circuitOpen = open
+	if open {
+		a.log.Warn("cloud circuit open, skipping cloud requests until the cooldown ends",
+			"cooldown_seconds", a.cfg.CircuitBreakerCooldownSeconds)
+	} else {
+		a.log.Info("cloud circuit closed")
 	}
+}
 
-	return a.cloud.Heartbeat(ctx, hb)
+// checkLatestVersion warns when the cloud reports a different connector
+// version than the one running. Each advertised version is reported once,
+// not on every heartbeat.
+func (a *Agent) checkLatestVersion(latest, updateURL string) {
+	if latest == "" || latest == a.version || latest == a.notifiedVersion {
+		return
+	}
+	a.notifiedVersion = latest
+	a.log.Warn("connector update available",
+		"current_version", a.version,
+		"latest_version", latest,
+		"update_url", updateURL,
+	)
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
 }
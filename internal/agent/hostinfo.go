@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"context"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/moonraker"
+)
+
+// printerHost returns the printer's host description for the heartbeat. It
+// is read once per connection: on the first heartbeat that reaches the
+// printer, and again after it has been unreachable, since a reconnect may
+// mean new hardware or an OS upgrade. Only the heartbeat loop calls it.
+func (a *Agent) printerHost(ctx context.Context, printerID int, mc *moonraker.Client, reachable bool) *cloud.PrinterHost {
+	if !reachable {
+		delete(a.hosts, printerID)
+		return nil
+	}
+	if host, ok := a.hosts[printerID]; ok {
+		return host
+	}
+
+	info, err := mc.GetSystemInfo(ctx)
+	if err != nil {
+		a.printerLog(printerID).Debug("moonraker system info failed", "error", err)
+		return nil
+	}
+	host := &cloud.PrinterHost{
+		CPUModel:      info.CPUModel,
+		CPUCount:      info.CPUCount,
+		Distribution:  info.Distribution,
+		PythonVersion: info.PythonVersion,
+	}
+	if info.MemoryUnits == "kB" {
+		host.TotalMemoryKB = info.TotalMemory
+	}
+	a.printerLog(printerID).Info("printer host",
+		"cpu_model", host.CPUModel,
+		"distribution", host.Distribution,
+		"python_version", host.PythonVersion,
+	)
+	a.hosts[printerID] = host
+	return host
+}
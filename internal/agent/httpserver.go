@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// serveHTTP runs an auxiliary HTTP server (metrics, probes, ...) until ctx is
+// cancelled, then shuts it down gracefully. Failing to bind is logged but is
+// not fatal: these servers are optional and must not take the connector down.
+func (a *Agent) serveHTTP(ctx context.Context, name, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	a.servers.Add(2)
+	go func() {
+		defer a.servers.Done()
+		a.log.Info("http server listening", "server", name, "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Error("http server failed", "server", name, "addr", addr, "error", err)
+		}
+	}()
+	go func() {
+		defer a.servers.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			a.log.Warn("http server shutdown failed", "server", name, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/util"
+)
+
+// runLoop calls fn every interval. After a failure the loop waits out the
+// backoff instead of the interval (not both). When the failure was a cloud
+// outage the wait is cut short as soon as another loop recovers from one:
+// once the cloud is reachable again every loop returns to its normal
+// cadence rather than sitting out a 60s backoff. Other failures (a
+// rejected request, an offline printer) are this loop's own and always
+// wait out the backoff.
+func (a *Agent) runLoop(ctx context.Context, failMsg string, every time.Duration, fn func(context.Context) error) error {
+	bo := util.NewBackoff(1*time.Second, 60*time.Second)
+	inOutage := false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		if err := fn(ctx); err != nil {
+			a.maybeRepair(ctx, err)
+			wait, _ := bo.Next()
+			a.log.Warn(failMsg, "error", err, "attempt", bo.Attempt(), "retry_in", wait)
+			inOutage = isCloudOutage(err)
+			sleep := util.SleepCtx
+			// A 401 is woken too: re-pairing signals once it has new
+			// credentials.
+			if inOutage || cloud.IsUnauthorized(err) {
+				sleep = a.recovery.sleep
+			}
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		bo.Reset()
+		// Only getting through an outage says the cloud is back; a loop
+		// that was healthy, or failing for its own reasons, says nothing new.
+		if inOutage {
+			a.recovery.signal()
+			inOutage = false
+		}
+		if err := util.SleepCtx(ctx, every-time.Since(start)); err != nil {
+			return err
+		}
+	}
+}
+
+// isCloudOutage reports whether err means the cloud was unreachable, rather
+// than a failure particular to one loop.
+func isCloudOutage(err error) bool {
+	return cloud.IsOutage(err) || errors.Is(err, cloud.ErrCircuitOpen)
+}
+
+// recovery lets loops in an error backoff be woken early by a loop that
+// just succeeded.
+type recovery struct {
+	mu sync.Mutex
+	ch chan struct{} // non-nil while some loop is backing off
+}
+
+// sleep waits for d, ctx, or the next signal, whichever comes first.
+func (r *recovery) sleep(ctx context.Context, d time.Duration) error {
+	r.mu.Lock()
+	if r.ch == nil {
+		r.ch = make(chan struct{})
+	}
+	ch := r.ch
+	r.mu.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+	case <-ch:
+	}
+	return nil
+}
+
+// signal wakes every loop currently in sleep.
+func (r *recovery) signal() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ch != nil {
+		close(r.ch)
+		r.ch = nil
+	}
+}
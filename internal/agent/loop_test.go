@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLoopTestAgent() *Agent {
+	return &Agent{log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+// runFor runs fn under runLoop until d has passed and returns how often it
+// was called.
+func runFor(a *Agent, d time.Duration, fn func(context.Context) error) *atomic.Int32 {
+	var calls atomic.Int32
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	go func() {
+		defer cancel()
+		a.runLoop(ctx, "test loop failed", 10*time.Millisecond, func(ctx context.Context) error {
+			calls.Add(1)
+			return fn(ctx)
+		})
+	}()
+	return &calls
+}
+
+func TestRunLoopHealthyLoopDoesNotWakeFailingOne(t *testing.T) {
+	a := newLoopTestAgent()
+	// The healthy loop succeeds every 10ms; the failing one is rejected for
+	// its own reasons and must sit out its ≥750ms backoff.
+	runFor(a, 300*time.Millisecond, func(context.Context) error { return nil })
+	failing := runFor(a, 300*time.Millisecond, func(context.Context) error { return errors.New("422 unprocessable") })
+
+	time.Sleep(350 * time.Millisecond)
+	if n := failing.Load(); n != 1 {
+		t.Errorf("failing loop ran %d times in 300ms; want 1 (woken by a healthy loop)", n)
+	}
+}
+
+func TestRunLoopOutageWokenOnRecovery(t *testing.T) {
+	a := newLoopTestAgent()
+	outage := &url.Error{Op: "Post", URL: "https://cloud.example.com", Err: errors.New("connection refused")}
+	calls := runFor(a, 500*time.Millisecond, func(context.Context) error { return outage })
+
+	time.Sleep(50 * time.Millisecond)
+	a.recovery.signal()
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("loop in an outage ran %d times after a recovery signal; want 2", n)
+	}
+}
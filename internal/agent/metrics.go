@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"net/http"
+	"strconv"
+
+	"printer-connector/internal/metrics"
+)
+
+// agentMetrics are always collected; they're only served when metrics_addr is set.
+type agentMetrics struct {
+	registry *metrics.Registry
+
+	heartbeatsSent   *metrics.CounterVec
+	snapshotsPushed  *metrics.CounterVec
+	commandsExecuted *metrics.CounterVec
+	printerReachable *metrics.GaugeVec
+}
+
+func newAgentMetrics() *agentMetrics {
+	r := metrics.NewRegistry()
+	return &agentMetrics{
+		registry:         r,
+		heartbeatsSent:   r.NewCounterVec("printer_connector_heartbeats_sent_total", "Heartbeats successfully sent to the cloud."),
+		snapshotsPushed:  r.NewCounterVec("printer_connector_snapshots_pushed_total", "Printer snapshots successfully pushed to the cloud."),
+		commandsExecuted: r.NewCounterVec("printer_connector_commands_executed_total", "Commands executed, by action and completion status.", "action", "status"),
+		printerReachable: r.NewGaugeVec("printer_connector_printer_reachable", "Whether the printer's Moonraker API was reachable at the last heartbeat (1) or not (0).", "printer_id"),
+	}
+}
+
+func (m *agentMetrics) setReachable(printerID int, reachable bool) {
+	v := 0.0
+	if reachable {
+		v = 1
+	}
+	m.printerReachable.Set(v, strconv.Itoa(printerID))
+}
+
+func (m *agentMetrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry.Handler())
+	return mux
+}
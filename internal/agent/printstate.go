@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/moonraker"
+)
+
+// idleStates are the print_stats states in which a new print may start:
+// nothing is running, whether or not the last job finished cleanly.
+var idleStates = []string{"standby", "complete", "cancelled", "error"}
+
+// actionStates lists, for actions that only make sense in some print
+// states, the print_stats.state values they are accepted in. Actions not
+// listed run in any state.
+var actionStates = map[string][]string{
+	"pause":            {"printing"},
+	"resume":           {"paused"},
+	"cancel":           {"printing", "paused"},
+	"start_print":      idleStates,
+	"upload_and_print": idleStates,
+}
+
+// checkPrintState rejects cmd when the printer's current print state
+// doesn't allow its action, so the dashboard gets a clear reason instead of
+// whatever Moonraker makes of it. The state is added to result.
+func checkPrintState(ctx context.Context, mc *moonraker.Client, cmd cloud.Command, result map[string]any) error {
+	allowed, ok := actionStates[cmd.Action]
+	if !ok {
+		return nil
+	}
+	state, err := mc.PrintState(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check print state: %w", err)
+	}
+	result["print_state"] = state
+	if !slices.Contains(allowed, state) {
+		return fmt.Errorf("cannot %s while printer is %s (requires %s)", cmd.Action, state, strings.Join(allowed, " or "))
+	}
+	return nil
+}
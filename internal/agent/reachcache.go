@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// reachCache remembers each printer's last successful Moonraker objects
+// query, so a heartbeat shortly after a snapshot (or another heartbeat)
+// reuses it as proof of reachability instead of querying again.
+type reachCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int]reachEntry
+}
+
+type reachEntry struct {
+	at      time.Time
+	latency time.Duration
+}
+
+func newReachCache(ttl time.Duration) *reachCache {
+	return &reachCache{ttl: ttl, entries: map[int]reachEntry{}}
+}
+
+// ok records a successful query of printerID that took latency.
+func (c *reachCache) ok(printerID int, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[printerID] = reachEntry{at: time.Now(), latency: latency}
+}
+
+// recent returns the latency of a successful query of printerID within the
+// TTL, if there was one.
+func (c *reachCache) recent(printerID int) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[printerID]
+	if !found || time.Since(e.at) > c.ttl {
+		return 0, false
+	}
+	return e.latency, true
+}
+
+// invalidate forgets printerID, e.g. after a command that may have changed
+// whether it still answers (restarts, power).
+func (c *reachCache) invalidate(printerID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, printerID)
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/config"
+)
+
+// Re-pairing kicks in after repairAfterUnauthorized consecutive 401s and is
+// attempted at most once per repairCooldown, so a bad token can't spin.
+const (
+	repairAfterUnauthorized = 3
+	repairCooldown          = 10 * time.Minute
+)
+
+// maybeRepair registers again with RepairToken (or a leftover PairingToken)
+// once the cloud has persistently rejected the connector secret, and swaps
+// in the new credentials. It is called from the loops on errors; without a
+// token it only logs, once per cooldown.
+func (a *Agent) maybeRepair(ctx context.Context, err error) {
+	if !cloud.IsUnauthorized(err) || a.cloud.UnauthorizedStreak() < repairAfterUnauthorized {
+		return
+	}
+	if !a.repairMu.TryLock() {
+		return // another loop is already on it
+	}
+	defer a.repairMu.Unlock()
+	if !a.lastRepair.IsZero() && time.Since(a.lastRepair) < repairCooldown {
+		return
+	}
+	a.lastRepair = time.Now()
+
+	a.cfgMu.RLock()
+	token := a.cfg.RepairToken
+	if token == "" {
+		token = a.cfg.PairingToken
+	}
+	a.cfgMu.RUnlock()
+	if token == "" {
+		a.log.Error("cloud keeps rejecting the connector credentials; set repair_token to re-pair automatically",
+			"unauthorized_streak", a.cloud.UnauthorizedStreak())
+		return
+	}
+
+	a.log.Warn("cloud keeps rejecting the connector credentials, re-pairing",
+		"unauthorized_streak", a.cloud.UnauthorizedStreak())
+	resp, err := a.cloud.Register(ctx, a.registerRequest(token))
+	if err != nil {
+		a.log.Error("re-pairing failed", "error", err, "retry_in", repairCooldown)
+		return
+	}
+
+	// Printer IDs and polling intervals are fixed for this run; only the
+	// credentials are swapped.
+	a.cfgMu.Lock()
+	a.cfg.ConnectorID = string(resp.Connector.ID)
+	a.cfg.ConnectorSecret = resp.Credentials.Secret
+	a.cfg.PairingToken = ""
+	saveErr := config.SaveAtomic(a.cfgPath, a.cfg)
+	a.cfgMu.Unlock()
+	if saveErr != nil {
+		a.log.Warn("failed to save re-paired credentials", "error", saveErr)
+	}
+
+	a.cloud.SetCredentials(string(resp.Connector.ID), resp.Credentials.Secret)
+	a.log.Info("re-paired successfully", "new_connector_id", string(resp.Connector.ID))
+	for i, p := range resp.Printers {
+		if i < len(a.cfg.Moonraker) && a.cfg.Moonraker[i].PrinterID != p.ID {
+			a.log.Warn("cloud assigned a different printer_id on re-pair; restart the connector to apply it",
+				"moonraker_name", a.cfg.Moonraker[i].Name,
+				"printer_id", a.cfg.Moonraker[i].PrinterID,
+				"new_printer_id", p.ID)
+		}
+	}
+	a.recovery.signal()
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"time"
+
+	"printer-connector/internal/config"
+)
+
+// schedule tracks when each printer is next due for a periodic task, so
+// printers with different intervals can share a single loop.
+type schedule struct {
+	next  map[int]time.Time
+	slack time.Duration
+}
+
+// newSchedule creates a schedule driven by a loop ticking every tick.
+// Printers are considered due up to half a tick early so that small delays
+// in the loop don't push a run back by a whole tick.
+func newSchedule(tick time.Duration) *schedule {
+	return &schedule{next: map[int]time.Time{}, slack: tick / 2}
+}
+
+// due reports whether printerID should run at now and, if so, books its next run.
+func (s *schedule) due(printerID int, now time.Time, every time.Duration) bool {
+	if next, ok := s.next[printerID]; ok && now.Add(s.slack).Before(next) {
+		return false
+	}
+	s.next[printerID] = now.Add(every)
+	return true
+}
+
+func (a *Agent) snapshotInterval(p config.MoonrakerPrinter) time.Duration {
+	if p.SnapshotSeconds > 0 {
+		return time.Duration(p.SnapshotSeconds) * time.Second
+	}
+	return time.Duration(a.cfg.PushSnapshotsSeconds) * time.Second
+}
+
+func (a *Agent) commandInterval(p config.MoonrakerPrinter) time.Duration {
+	if p.CommandSeconds > 0 {
+		return time.Duration(p.CommandSeconds) * time.Second
+	}
+	return time.Duration(a.cfg.PollCommandsSeconds) * time.Second
+}
+
+// minInterval returns the shortest interval across all printers, which is
+// the cadence the shared loop has to tick at.
+func (a *Agent) minInterval(interval func(config.MoonrakerPrinter) time.Duration, fallback time.Duration) time.Duration {
+	min := time.Duration(0)
+	for _, p := range a.cfg.Moonraker {
+		if d := interval(p); min == 0 || d < min {
+			min = d
+		}
+	}
+	if min <= 0 {
+		return fallback
+	}
+	return min
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"printer-connector/internal/cloud"
+)
+
+// snapshotBuffer holds snapshots that couldn't be pushed, oldest first, so
+// they can be delivered once the cloud is reachable again. It is persisted
+// as JSON lines so it survives a restart, and bounded by maxBytes: the
+// oldest entries are dropped on overflow.
+type snapshotBuffer struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int
+	entries  [][]byte // one encoded cloud.Snapshot each
+	size     int
+}
+
+// loadSnapshotBuffer reads the buffer at path. A missing file yields an
+// empty buffer; undecodable lines are skipped.
+func loadSnapshotBuffer(path string, maxBytes int) (*snapshotBuffer, error) {
+	b := &snapshotBuffer{path: path, maxBytes: maxBytes}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return b, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxBytes+1)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if !json.Valid(line) {
+			continue
+		}
+		b.entries = append(b.entries, append([]byte(nil), line...))
+		b.size += len(line) + 1
+	}
+	b.trim()
+	return b, sc.Err()
+}
+
+func (b *snapshotBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// add appends snaps, dropping the oldest entries if the cap is exceeded,
+// and persists the buffer. It returns how many entries were dropped.
+func (b *snapshotBuffer) add(snaps []cloud.Snapshot) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range snaps {
+		line, err := json.Marshal(s)
+		if err != nil {
+			return 0, err
+		}
+		b.entries = append(b.entries, line)
+		b.size += len(line) + 1
+	}
+	dropped := b.trim()
+	return dropped, b.save()
+}
+
+// peek decodes up to n of the oldest snapshots without removing them.
+func (b *snapshotBuffer) peek(n int) []cloud.Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > len(b.entries) {
+		n = len(b.entries)
+	}
+	out := make([]cloud.Snapshot, 0, n)
+	for _, line := range b.entries[:n] {
+		var s cloud.Snapshot
+		if err := json.Unmarshal(line, &s); err == nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// drop removes the n oldest entries and persists the buffer.
+func (b *snapshotBuffer) drop(n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n > len(b.entries) {
+		n = len(b.entries)
+	}
+	for _, line := range b.entries[:n] {
+		b.size -= len(line) + 1
+	}
+	b.entries = b.entries[n:]
+	return b.save()
+}
+
+func (b *snapshotBuffer) trim() int {
+	dropped := 0
+	for b.size > b.maxBytes && len(b.entries) > 0 {
+		b.size -= len(b.entries[0]) + 1
+		b.entries = b.entries[1:]
+		dropped++
+	}
+	return dropped
+}
+
+// save writes the buffer atomically (temp + rename), like commandLog.save.
+// An empty buffer removes the file.
+func (b *snapshotBuffer) save() error {
+	if len(b.entries) == 0 {
+		if err := os.Remove(b.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Grow(b.size)
+	for _, line := range b.entries {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
@@ -2,9 +2,13 @@ package agent
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"time"
 
 	"printer-connector/internal/cloud"
+	"printer-connector/internal/moonraker"
+	"printer-connector/internal/util"
 )
 
 func (a *Agent) collectAndPushSnapshots(ctx context.Context) error {
@@ -16,42 +20,310 @@ func (a *Agent) collectAndPushSnapshots(ctx context.Context) error {
 		if mc == nil {
 			continue
 		}
+		if a.websocketLive(p.PrinterID) {
+			continue
+		}
+		if !a.snapSched.due(p.PrinterID, now, a.snapshotInterval(p)) {
+			continue
+		}
 
-		payload, err := mc.QueryObjects(ctx)
+		payload, printerAt, err := a.querySnapshot(ctx, p.PrinterID, mc)
 		if err != nil {
-			a.log.Warn("moonraker query failed", "printer_id", p.PrinterID, "error", err)
+			a.printerLog(p.PrinterID).Warn("moonraker query failed", "error", err)
+			continue
+		}
+		if a.cfg.DedupeSnapshots && a.snapshotUnchanged(p.PrinterID, payload, now) {
 			continue
 		}
 
+		// Prefer the printer's clock: connector hosts without an RTC drift,
+		// and the printer's is what its state was measured against.
+		capturedAt, source := now, cloud.ClockLocal
+		if !printerAt.IsZero() {
+			capturedAt, source = printerAt.UTC(), cloud.ClockPrinter
+		}
 		snaps = append(snaps, cloud.Snapshot{
-			PrinterID:  p.PrinterID,
-			CapturedAt: now.Format(time.RFC3339),
-			Payload:    payload,
+			PrinterID:   p.PrinterID,
+			CapturedAt:  capturedAt.Format(time.RFC3339),
+			Payload:     a.snapshotPayload(payload),
+			ClockSource: source,
 		})
 	}
 
+	// Deliver anything left over from an outage first so the cloud sees
+	// snapshots in order.
+	if err := a.flushSnapshotBuffer(ctx, 0); err != nil {
+		a.bufferSnapshots(snaps)
+		return err
+	}
+
 	if len(snaps) == 0 {
 		return nil
 	}
 
-	resp, err := a.cloud.PushSnapshots(ctx, cloud.SnapshotsBatchRequest{Snapshots: snaps})
+	// Push in batches; a failed batch is buffered and the rest still go out.
+	var firstErr error
+	pushed, inserted := 0, 0
+	for start := 0; start < len(snaps); start += a.cfg.MaxSnapshotBatchSize {
+		batch := snaps[start:min(start+a.cfg.MaxSnapshotBatchSize, len(snaps))]
+		resp, err := a.cloud.PushSnapshots(ctx, cloud.SnapshotsBatchRequest{Snapshots: batch})
+		if err != nil {
+			a.log.Warn("snapshot batch push failed", "count", len(batch), "error", err)
+			a.bufferSnapshots(batch)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		a.admin.snapshotsPushed(batch)
+		pushed += len(batch)
+		inserted += resp.Inserted
+	}
+	if pushed > 0 {
+		a.metrics.snapshotsPushed.Add(float64(pushed))
+		a.log.Info("snapshots pushed", "count", pushed, "inserted", inserted)
+	}
+	return firstErr
+}
+
+type snapshotDigest struct {
+	sum      [sha256.Size]byte
+	pushedAt time.Time
+}
+
+// snapshotUnchanged reports whether raw has the same status as the last
+// snapshot pushed for printerID, less than SnapshotKeepaliveSeconds ago.
+// Otherwise raw becomes the new reference. Only the status is hashed: the
+// envelope's eventtime changes on every query.
+func (a *Agent) snapshotUnchanged(printerID int, raw map[string]any, now time.Time) bool {
+	var v any = raw
+	if status, ok := moonraker.ExtractStatus(raw); ok {
+		v = status
+		// Changes to fields that are never pushed don't count.
+		if a.snapFields != nil {
+			v = a.snapFields.prune(status)
+		}
+	}
+	b, err := json.Marshal(v) // map keys are sorted, so equal states hash equally
 	if err != nil {
-		return err
+		return false
+	}
+	sum := sha256.Sum256(b)
+
+	keepalive := time.Duration(a.cfg.SnapshotKeepaliveSeconds) * time.Second
+	if last, ok := a.lastSnaps[printerID]; ok && last.sum == sum && now.Sub(last.pushedAt) < keepalive {
+		return true
+	}
+	a.lastSnaps[printerID] = snapshotDigest{sum: sum, pushedAt: now}
+	return false
+}
+
+// bufferSnapshots keeps snaps on disk for a later flushSnapshotBuffer.
+func (a *Agent) bufferSnapshots(snaps []cloud.Snapshot) {
+	if len(snaps) == 0 {
+		return
+	}
+	dropped, err := a.snapBuf.add(snaps)
+	if err != nil {
+		a.log.Warn("failed to persist snapshot buffer", "error", err)
+	}
+	if dropped > 0 {
+		a.log.Warn("snapshot buffer full, dropped oldest", "dropped", dropped)
+	}
+	a.log.Debug("snapshots buffered", "count", len(snaps), "buffered", a.snapBuf.len())
+}
+
+// flushSnapshotBuffer pushes buffered snapshots oldest first, in batches of
+// MaxSnapshotBatchSize, stopping at the first failure. pace, when > 0, is
+// waited between batches.
+func (a *Agent) flushSnapshotBuffer(ctx context.Context, pace time.Duration) error {
+	flushed := 0
+	for {
+		batch := a.snapBuf.peek(a.cfg.MaxSnapshotBatchSize)
+		if len(batch) == 0 {
+			break
+		}
+		if flushed > 0 && pace > 0 {
+			if err := util.SleepCtx(ctx, pace); err != nil {
+				return err
+			}
+		}
+		if _, err := a.cloud.PushSnapshots(ctx, cloud.SnapshotsBatchRequest{Snapshots: batch}); err != nil {
+			return err
+		}
+		if err := a.snapBuf.drop(len(batch)); err != nil {
+			a.log.Warn("failed to persist snapshot buffer", "error", err)
+		}
+		a.admin.snapshotsPushed(batch)
+		a.metrics.snapshotsPushed.Add(float64(len(batch)))
+		flushed += len(batch)
+	}
+	if flushed > 0 {
+		a.log.Info("buffered snapshots flushed", "count", flushed)
 	}
-	a.log.Info("snapshots pushed", "count", len(snaps), "inserted", resp.Inserted)
 	return nil
 }
 
+// startupFlush drains the snapshot buffer left from before a restart at
+// StartupFlushBatchesPerSecond, before the loops start pushing fresh
+// snapshots. A failure is only logged; the snapshot loop keeps retrying.
+func (a *Agent) startupFlush(ctx context.Context) {
+	n := a.snapBuf.len()
+	if n == 0 {
+		return
+	}
+	pace := time.Duration(float64(time.Second) / a.cfg.StartupFlushBatchesPerSecond)
+	a.log.Info("flushing buffered snapshots", "count", n, "batches_per_second", a.cfg.StartupFlushBatchesPerSecond)
+	if err := a.flushSnapshotBuffer(ctx, pace); err != nil && ctx.Err() == nil {
+		a.log.Warn("startup snapshot flush failed", "remaining", a.snapBuf.len(), "error", err)
+	}
+}
+
 func (a *Agent) pushSingleSnapshot(ctx context.Context, printerID int, payload map[string]any) error {
 	req := cloud.SnapshotsBatchRequest{
 		Snapshots: []cloud.Snapshot{
 			{
-				PrinterID:  printerID,
-				CapturedAt: time.Now().UTC().Format(time.RFC3339),
-				Payload:    payload,
+				PrinterID:   printerID,
+				CapturedAt:  time.Now().UTC().Format(time.RFC3339),
+				Payload:     a.snapshotPayload(payload),
+				ClockSource: cloud.ClockLocal,
 			},
 		},
 	}
-	_, err := a.cloud.PushSnapshots(ctx, req)
-	return err
+	if _, err := a.cloud.PushSnapshots(ctx, req); err != nil {
+		a.bufferSnapshots(req.Snapshots)
+		return err
+	}
+	a.admin.snapshotsPushed(req.Snapshots)
+	a.metrics.snapshotsPushed.Inc()
+	return nil
+}
+
+// snapshotPayload applies SnapshotFieldAllowlist, strips the RPC envelope
+// when FlattenSnapshots is set and adds the derived pause_reason while
+// paused. Responses of an unexpected shape are pushed unchanged, or pruned
+// as a whole under an allowlist. raw itself is never modified.
+func (a *Agent) snapshotPayload(raw map[string]any) map[string]any {
+	status, ok := moonraker.ExtractStatus(raw)
+	if !ok {
+		if a.snapFields != nil {
+			return a.snapFields.prune(raw)
+		}
+		return raw
+	}
+	// pause_reason is derived, so it's kept even if its inputs aren't.
+	reason := pauseReason(status)
+	if a.snapFields != nil {
+		status = a.snapFields.prune(status)
+		raw = moonraker.ReplaceStatus(raw, status)
+	}
+
+	var out map[string]any
+	if a.cfg.FlattenSnapshots {
+		out = make(map[string]any, len(status)+1)
+		for k, v := range status {
+			out[k] = v
+		}
+	} else {
+		out = make(map[string]any, len(raw)+1)
+		for k, v := range raw {
+			out[k] = v
+		}
+	}
+	if reason != "" {
+		out["pause_reason"] = reason
+	}
+	return out
+}
+
+// pauseReason derives why a print is paused: "filament_runout" when an
+// enabled filament sensor reports no filament, otherwise "user". It returns
+// "" when the print isn't paused.
+func pauseReason(status map[string]any) string {
+	paused := false
+	if ps, ok := status["print_stats"].(map[string]any); ok {
+		paused = ps["state"] == "paused"
+	}
+	if pr, ok := status["pause_resume"].(map[string]any); ok && pr["is_paused"] == true {
+		paused = true
+	}
+	if !paused {
+		return ""
+	}
+
+	for name, v := range status {
+		if !moonraker.IsFilamentSensor(name) {
+			continue
+		}
+		sensor, _ := v.(map[string]any)
+		if sensor["enabled"] != false && sensor["filament_detected"] == false {
+			return "filament_runout"
+		}
+	}
+	return "user"
+}
+
+// snapshotObjects returns the configured snapshot objects, or Moonraker's
+// defaults plus the printer's filament sensors when none are set.
+func (a *Agent) snapshotObjects(ctx context.Context, printerID int, mc *moonraker.Client) map[string][]string {
+	if len(a.cfg.SnapshotObjects) > 0 {
+		return a.cfg.SnapshotObjects
+	}
+	objects := moonraker.DefaultSnapshotObjects()
+	for _, name := range a.filamentSensors(ctx, printerID, mc) {
+		objects[name] = nil
+	}
+	return objects
+}
+
+// filamentSensors lists the printer's filament sensor objects. The result
+// is cached per printer once Moonraker has answered; a failed lookup is
+// retried on the next snapshot.
+func (a *Agent) filamentSensors(ctx context.Context, printerID int, mc *moonraker.Client) []string {
+	a.sensorsMu.Lock()
+	cached, ok := a.sensors[printerID]
+	a.sensorsMu.Unlock()
+	if ok {
+		return cached
+	}
+
+	names, err := mc.ListObjects(ctx)
+	if err != nil {
+		a.printerLog(printerID).Debug("moonraker object list failed", "error", err)
+		return nil
+	}
+	sensors := []string{}
+	for _, name := range names {
+		if moonraker.IsFilamentSensor(name) {
+			sensors = append(sensors, name)
+		}
+	}
+
+	a.sensorsMu.Lock()
+	a.sensors[printerID] = sensors
+	a.sensorsMu.Unlock()
+	return sensors
+}
+
+// querySnapshot queries the snapshot objects, also returning the printer
+// host's clock at the time (zero if unknown). It fails for a printer refused
+// by the Moonraker version check.
+func (a *Agent) querySnapshot(ctx context.Context, printerID int, mc *moonraker.Client) (map[string]any, time.Time, error) {
+	if err := a.checkMoonraker(ctx, printerID, mc); err != nil {
+		return nil, time.Time{}, err
+	}
+	objects := map[string]any{}
+	for name, fields := range a.snapshotObjects(ctx, printerID, mc) {
+		if len(fields) == 0 {
+			objects[name] = nil
+		} else {
+			objects[name] = fields
+		}
+	}
+	start := time.Now()
+	payload, at, err := mc.QueryObjectsAt(ctx, objects)
+	if err == nil {
+		a.reachCache.ok(printerID, time.Since(start))
+	}
+	return payload, at, err
 }
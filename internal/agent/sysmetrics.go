@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"printer-connector/internal/cloud"
+	"printer-connector/internal/util"
+)
+
+// systemStatus samples host health for the heartbeat. Every source is
+// optional: anything missing (non-Linux, no thermal zone) is left zero.
+func (a *Agent) systemStatus() *cloud.SystemStatus {
+	s := &cloud.SystemStatus{}
+
+	if b, err := os.ReadFile("/proc/loadavg"); err == nil {
+		f := strings.Fields(string(b))
+		if len(f) >= 3 {
+			s.Load1, _ = strconv.ParseFloat(f[0], 64)
+			s.Load5, _ = strconv.ParseFloat(f[1], 64)
+			s.Load15, _ = strconv.ParseFloat(f[2], 64)
+		}
+	}
+
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			// e.g. "MemAvailable:    712344 kB"
+			f := strings.Fields(sc.Text())
+			if len(f) < 2 {
+				continue
+			}
+			kb, err := strconv.ParseUint(f[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch f[0] {
+			case "MemTotal:":
+				s.MemTotalBytes = kb * 1024
+			case "MemAvailable:":
+				s.MemAvailableBytes = kb * 1024
+			}
+		}
+		f.Close()
+	}
+
+	if free, total, err := util.DiskSpace(a.cfg.StateDir); err == nil {
+		s.DiskFreeBytes, s.DiskTotalBytes = free, total
+	}
+
+	// Millidegrees Celsius; zone 0 is the SoC on Raspberry Pi and most SBCs.
+	if b, err := os.ReadFile("/sys/class/thermal/thermal_zone0/temp"); err == nil {
+		if milli, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64); err == nil {
+			s.CPUTemperatureC = milli / 1000
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"printer-connector/internal/config"
+	"printer-connector/internal/moonraker"
+	"printer-connector/internal/util"
+)
+
+// wsMinPushInterval coalesces websocket deltas so a printing machine (whose
+// toolhead position changes constantly) doesn't push a snapshot per update.
+const wsMinPushInterval = 2 * time.Second
+
+// websocketSnapshotsLoop keeps a live Moonraker subscription for p and pushes
+// snapshots as its state changes. While the socket is down the printer falls
+// back to the regular polling snapshots loop.
+func (a *Agent) websocketSnapshotsLoop(ctx context.Context, p config.MoonrakerPrinter) {
+	mc := a.moons[p.PrinterID]
+	if mc == nil {
+		return
+	}
+
+	bo := util.NewBackoff(1*time.Second, 60*time.Second)
+	for {
+		connected, err := a.streamSnapshots(ctx, p.PrinterID, mc)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			bo.Reset()
+		}
+		a.printerLog(p.PrinterID).Warn("moonraker websocket unavailable, using polling", "error", err)
+
+		wait, _ := bo.Next()
+		if util.SleepCtx(ctx, wait) != nil {
+			return
+		}
+	}
+}
+
+// streamSnapshots runs a single subscription until it drops. It reports
+// whether the subscription was established at all.
+func (a *Agent) streamSnapshots(ctx context.Context, printerID int, mc *moonraker.Client) (bool, error) {
+	if err := a.checkMoonraker(ctx, printerID, mc); err != nil {
+		return false, err
+	}
+	updates, err := mc.Subscribe(ctx, a.snapshotObjects(ctx, printerID, mc))
+	if err != nil {
+		return false, err
+	}
+
+	a.setWebsocketLive(printerID, true)
+	defer a.setWebsocketLive(printerID, false)
+	a.printerLog(printerID).Info("moonraker websocket subscribed")
+
+	flush := time.NewTicker(wsMinPushInterval)
+	defer flush.Stop()
+
+	state := map[string]any{}
+	dirty := false
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case delta, ok := <-updates:
+			if !ok {
+				return true, errors.New("moonraker websocket closed")
+			}
+			mergeStatus(state, delta)
+			dirty = true
+		case <-flush.C:
+			if !dirty {
+				continue
+			}
+			// Same envelope as an HTTP objects query, so the cloud sees one shape.
+			payload := map[string]any{"result": map[string]any{"status": state}}
+			if err := a.pushSingleSnapshot(ctx, printerID, payload); err != nil {
+				a.printerLog(printerID).Warn("websocket snapshot push failed", "error", err)
+				continue
+			}
+			dirty = false
+		}
+	}
+}
+
+// mergeStatus applies a Moonraker status delta to state in place. Nested
+// objects are merged field by field; everything else is replaced.
+func mergeStatus(state, delta map[string]any) {
+	for k, v := range delta {
+		dm, ok := v.(map[string]any)
+		if !ok {
+			state[k] = v
+			continue
+		}
+		sm, ok := state[k].(map[string]any)
+		if !ok {
+			sm = map[string]any{}
+			state[k] = sm
+		}
+		mergeStatus(sm, dm)
+	}
+}
+
+func (a *Agent) setWebsocketLive(printerID int, live bool) {
+	a.wsMu.Lock()
+	defer a.wsMu.Unlock()
+	if live {
+		a.wsLive[printerID] = true
+	} else {
+		delete(a.wsLive, printerID)
+	}
+}
+
+func (a *Agent) websocketLive(printerID int) bool {
+	a.wsMu.Lock()
+	defer a.wsMu.Unlock()
+	return a.wsLive[printerID]
+}
@@ -1,14 +1,18 @@
 package backup
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"printer-connector/internal/util"
 )
 
 // Options configures backup archive creation
@@ -20,6 +24,39 @@ type Options struct {
 	IncludeLogs     bool
 	OutputPath      string // temp file path for archive
 	MaxSizeBytes    int64  // safety limit (0 = no limit)
+
+	// ExtraDirs adds directories beyond the four above (e.g. "timelapse",
+	// "systemd"), relative to PrinterDataRoot; paths escaping it are
+	// rejected.
+	ExtraDirs []string
+
+	// Guards against pathological trees (0 = no limit): the number of files
+	// and symlinks archived and the size of any single file.
+	MaxFiles         int
+	MaxFileSizeBytes int64
+
+	// MinFreeBytes is extra headroom Create insists on leaving on the
+	// OutputPath filesystem beyond the estimated archive size plus 10%.
+	MinFreeBytes int64
+
+	// EncryptionKey (32 bytes), when set, encrypts the archive with
+	// AES-256-GCM (see EncryptionScheme). SHA256 and SizeBytes then
+	// describe the ciphertext.
+	EncryptionKey []byte
+
+	// Format is the archive container (default FormatTarGz).
+	Format Format
+
+	// IncrementalSince, when non-zero, archives only files modified after
+	// it. Restore such archives on top of the previous full backup.
+	IncrementalSince time.Time
+
+	// Glob patterns matched against each file's base name (filepath.Match
+	// syntax). A file is archived if it matches any include pattern (or no
+	// include patterns are set) and no exclude pattern. When both are empty
+	// the legacy default applies: only .cfg files, skipping printer-*_*.cfg.
+	IncludePatterns []string
+	ExcludePatterns []string
 }
 
 // Result contains metadata about the created backup archive
@@ -27,64 +64,256 @@ type Result struct {
 	ArchivePath string
 	SizeBytes   int64
 	SHA256      string
+
+	// Format and its file Extension (".tar.gz" or ".zip").
+	Format    Format
+	Extension string
+
+	// SkippedSymlinks lists links (relative to printer_data) left out
+	// because their target is broken or outside printer_data.
+	SkippedSymlinks []string
+
+	// FileCount is the number of files (and symlinks) archived, as listed
+	// in the archive's manifest.
+	FileCount int
+
+	// Set when Options.EncryptionKey was used. Nonce is the hex nonce
+	// prefix, also stored in the archive header.
+	Encrypted        bool
+	EncryptionScheme string
+	Nonce            string
+}
+
+// Create builds an archive (tar.gz unless Options.Format says otherwise) of
+// selected printer_data directories and returns metadata including SHA256 hash.
+// Cancelling ctx stops it between files. On failure no partial archive is
+// left at OutputPath.
+func Create(ctx context.Context, opts Options) (*Result, error) {
+	cleanRoot, dirs, err := prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDiskSpace(ctx, opts, cleanRoot, dirs); err != nil {
+		return nil, err
+	}
+
+	// Create output file
+	outFile, err := os.Create(opts.OutputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	complete := false
+	defer func() {
+		if outFile != nil {
+			outFile.Close()
+		}
+		// A partial archive would only be mistaken for a finished one.
+		if !complete {
+			os.Remove(opts.OutputPath)
+		}
+	}()
+
+	res, err := writeArchive(ctx, opts, cleanRoot, dirs, outFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := outFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close output file: %w", err)
+	}
+	outFile = nil // Prevent defer from closing again
+
+	// Get final file size
+	fileInfo, err := os.Stat(opts.OutputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat output file: %w", err)
+	}
+
+	res.ArchivePath = opts.OutputPath
+	res.SizeBytes = fileInfo.Size()
+	complete = true
+	return res, nil
 }
 
-// Create builds a tar.gz archive of selected printer_data directories
-// and returns metadata including SHA256 hash.
-func Create(opts Options) (*Result, error) {
+// CreateStream writes the archive directly to w instead of a file,
+// so it can be piped into an upload without a temporary copy on disk.
+// OutputPath is ignored and Result.ArchivePath is left empty.
+func CreateStream(ctx context.Context, opts Options, w io.Writer) (*Result, error) {
+	cleanRoot, dirs, err := prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+	return writeArchive(ctx, opts, cleanRoot, dirs, w)
+}
+
+// prepare validates opts and returns the cleaned root and directories to archive.
+func prepare(opts Options) (string, []string, error) {
 	// Validate printer_data root exists
 	if opts.PrinterDataRoot == "" {
-		return nil, fmt.Errorf("printer_data_root is required")
+		return "", nil, fmt.Errorf("printer_data_root is required")
 	}
 
 	cleanRoot := filepath.Clean(opts.PrinterDataRoot)
 	if _, err := os.Stat(cleanRoot); err != nil {
-		return nil, fmt.Errorf("printer_data_root does not exist: %w", err)
+		return "", nil, fmt.Errorf("printer_data_root does not exist: %w", err)
+	}
+
+	dirs, err := opts.Dirs()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(dirs) == 0 {
+		return "", nil, fmt.Errorf("no directories selected for backup")
+	}
+
+	// Reject malformed globs up front rather than silently matching nothing
+	for _, p := range append(append([]string{}, opts.IncludePatterns...), opts.ExcludePatterns...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid file pattern %q: %w", p, err)
+		}
 	}
 
-	// Build list of directories to include
+	return cleanRoot, dirs, nil
+}
+
+// Dirs lists the directories to archive, relative to PrinterDataRoot: the
+// enabled common ones, then ExtraDirs (cleaned and slash-separated).
+func (o Options) Dirs() ([]string, error) {
 	dirs := []string{}
-	if opts.IncludeConfig {
+	if o.IncludeConfig {
 		dirs = append(dirs, "config")
 	}
-	if opts.IncludeDatabase {
+	if o.IncludeDatabase {
 		dirs = append(dirs, "database")
 	}
-	if opts.IncludeGcodes {
+	if o.IncludeGcodes {
 		dirs = append(dirs, "gcodes")
 	}
-	if opts.IncludeLogs {
+	if o.IncludeLogs {
 		dirs = append(dirs, "logs")
 	}
 
-	if len(dirs) == 0 {
-		return nil, fmt.Errorf("no directories selected for backup")
+	for _, d := range o.ExtraDirs {
+		clean := filepath.Clean(filepath.FromSlash(d))
+		if d == "" || clean == "." || !filepath.IsLocal(clean) {
+			return nil, fmt.Errorf("invalid extra directory %q: must be a path inside printer_data", d)
+		}
+		dirs = append(dirs, filepath.ToSlash(clean))
 	}
 
-	// Create output file
-	outFile, err := os.Create(opts.OutputPath)
+	// Drop duplicates and directories inside another selected one, so no
+	// file is archived twice.
+	var out []string
+	for i, d := range dirs {
+		covered := false
+		for j, other := range dirs {
+			if (other == d && j < i) || strings.HasPrefix(d, other+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ErrInsufficientSpace is returned (wrapped) by Create when the output
+// filesystem can't hold the archive with the required headroom.
+var ErrInsufficientSpace = errors.New("insufficient disk space for backup")
+
+// checkDiskSpace fails early, before anything is written, if the archive
+// (estimated as the uncompressed size of the selected files + 10%) plus
+// MinFreeBytes won't fit on the OutputPath filesystem. Where free space
+// can't be determined the check is skipped.
+func checkDiskSpace(ctx context.Context, opts Options, cleanRoot string, dirs []string) error {
+	free, _, err := util.DiskSpace(filepath.Dir(opts.OutputPath))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create output file: %w", err)
+		return nil
 	}
-	defer func() {
-		if outFile != nil {
-			outFile.Close()
+
+	estimate, err := estimateSize(ctx, opts, cleanRoot, dirs)
+	if err != nil {
+		return fmt.Errorf("failed to estimate backup size: %w", err)
+	}
+	need := estimate + estimate/10 + opts.MinFreeBytes
+	if uint64(need) > free {
+		return fmt.Errorf("%w: need about %d bytes, %d available on %s", ErrInsufficientSpace, need, free, filepath.Dir(opts.OutputPath))
+	}
+	return nil
+}
+
+// estimateSize sums the sizes of the files writeArchive would include.
+func estimateSize(ctx context.Context, opts Options, cleanRoot string, dirs []string) (int64, error) {
+	var total int64
+	for _, dir := range dirs {
+		dirPath := filepath.Join(cleanRoot, dir)
+		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+			continue
 		}
-	}()
+		err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if info.IsDir() && info.Name() == "Helper-Script" {
+				return filepath.SkipDir
+			}
+			if info.Mode().IsRegular() && opts.includeFile(info.Name()) && opts.changed(info) {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
 
-	// Setup hash writer
+// writeArchive streams the archive of dirs under cleanRoot to w, hashing and
+// counting the compressed bytes as they are written. ctx is checked before
+// each entry.
+func writeArchive(ctx context.Context, opts Options, cleanRoot string, dirs []string, w io.Writer) (*Result, error) {
+	// Setup hash writer and byte counter
 	hasher := sha256.New()
-	multiWriter := io.MultiWriter(outFile, hasher)
-
-	// Create gzip writer
-	gzWriter := gzip.NewWriter(multiWriter)
-	defer gzWriter.Close()
+	counter := &countingWriter{}
+	multiWriter := io.MultiWriter(w, hasher, counter)
+
+	// Encryption sits between the archive and the output, so the hash
+	// covers the bytes actually uploaded.
+	var archiveOut io.Writer = multiWriter
+	var enc *encryptWriter
+	var nonce []byte
+	if len(opts.EncryptionKey) > 0 {
+		var err error
+		if enc, nonce, err = newEncryptWriter(multiWriter, opts.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("failed to set up encryption: %w", err)
+		}
+		archiveOut = enc
+	}
 
-	// Create tar writer with PAX format (supports long filenames)
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
+	format, err := ParseFormat(string(opts.Format))
+	if err != nil {
+		return nil, err
+	}
+	aw := newArchiveWriter(format, archiveOut)
 
 	var totalSize int64
+	var fileCount int
+	var skipped []string
+	var files []string
+
+	// Symlink targets are checked against the resolved root, in case
+	// printer_data itself is a link.
+	resolvedRoot, err := filepath.EvalSymlinks(cleanRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve printer_data_root: %w", err)
+	}
 
 	// Add each directory to archive
 	for _, dir := range dirs {
@@ -100,6 +329,9 @@ func Create(opts Options) (*Result, error) {
 			if err != nil {
 				return err
 			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
 			// Validate path is within printer_data root (security check)
 			cleanPath := filepath.Clean(path)
@@ -117,17 +349,44 @@ func Create(opts Options) (*Result, error) {
 				return nil
 			}
 
-			// Only include .cfg files
-			if !strings.HasSuffix(info.Name(), ".cfg") {
+			if info.Mode()&os.ModeSymlink != 0 {
+				if !opts.includeFile(info.Name()) || !opts.changed(info) {
+					return nil
+				}
+				// A tree of links is as costly to restore as one of files.
+				if opts.MaxFiles > 0 && fileCount >= opts.MaxFiles {
+					return fmt.Errorf("archive exceeds limit of %d files", opts.MaxFiles)
+				}
+				wrote, err := writeSymlink(aw, cleanRoot, resolvedRoot, path, info)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(cleanRoot, path)
+				if wrote {
+					fileCount++
+					files = append(files, filepath.ToSlash(rel))
+				} else {
+					skipped = append(skipped, filepath.ToSlash(rel))
+				}
+				return nil
+			}
+
+			// Skip sockets, devices and FIFOs.
+			if !info.Mode().IsRegular() {
 				return nil
 			}
 
-			// Skip printer-*_*.cfg files (but keep printer.cfg)
-			if strings.HasPrefix(info.Name(), "printer-") && strings.Contains(info.Name(), "_") && info.Name() != "printer.cfg" {
+			if !opts.includeFile(info.Name()) || !opts.changed(info) {
 				return nil
 			}
 
-			// Check size limit
+			// Check limits
+			if opts.MaxFiles > 0 && fileCount >= opts.MaxFiles {
+				return fmt.Errorf("archive exceeds limit of %d files", opts.MaxFiles)
+			}
+			if opts.MaxFileSizeBytes > 0 && info.Size() > opts.MaxFileSizeBytes {
+				return fmt.Errorf("file %s (%d bytes) exceeds per-file limit of %d bytes", path, info.Size(), opts.MaxFileSizeBytes)
+			}
 			if opts.MaxSizeBytes > 0 && totalSize+info.Size() > opts.MaxSizeBytes {
 				return fmt.Errorf("archive size exceeds limit of %d bytes", opts.MaxSizeBytes)
 			}
@@ -137,44 +396,23 @@ func Create(opts Options) (*Result, error) {
 			if err != nil {
 				return fmt.Errorf("failed to calculate relative path: %w", err)
 			}
-			// Use forward slashes for tar archives (Unix convention)
+			// Use forward slashes in archives (Unix convention)
 			relPath = filepath.ToSlash(relPath)
 
-			// Create minimal tar header - use GNU format which is more lenient
-			header := &tar.Header{
-				Typeflag: tar.TypeReg,
-				Name:     relPath,
-				Size:     info.Size(),
-				Mode:     0644, // Simple fixed permissions
-				ModTime:  info.ModTime(),
-				Format:   tar.FormatGNU, // GNU format is more permissive than PAX
-			}
-
-			// Write header
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return fmt.Errorf("failed to write tar header: %w", err)
-			}
-
 			// Open and copy file contents
 			file, err := os.Open(path)
 			if err != nil {
 				return fmt.Errorf("failed to open file %s: %w", path, err)
 			}
-
-			// Use LimitReader to ensure we don't write more than header.Size
-			written, err := io.Copy(tarWriter, io.LimitReader(file, header.Size))
+			err = aw.writeFile(relPath, info.Size(), info.ModTime(), file)
 			file.Close() // Close immediately after copying
-			
 			if err != nil {
-				return fmt.Errorf("failed to write file %s to archive: %w", path, err)
-			}
-			
-			// Verify we wrote the expected amount
-			if written != header.Size {
-				return fmt.Errorf("size mismatch for %s: expected %d bytes, wrote %d bytes", path, header.Size, written)
+				return err
 			}
 
-			totalSize += written
+			totalSize += info.Size()
+			fileCount++
+			files = append(files, relPath)
 			return nil
 		})
 
@@ -183,34 +421,115 @@ func Create(opts Options) (*Result, error) {
 		}
 	}
 
-	// Close writers to flush buffers
-	if err := tarWriter.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	if err := writeManifest(aw, opts, files); err != nil {
+		return nil, err
 	}
-	if err := gzWriter.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	if err := aw.Close(); err != nil {
+		return nil, err
 	}
-	if err := outFile.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close output file: %w", err)
+
+	res := &Result{
+		Format:          format,
+		Extension:       format.Extension(),
+		SkippedSymlinks: skipped,
+		FileCount:       len(files),
 	}
-	outFile = nil // Prevent defer from closing again
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finish encryption: %w", err)
+		}
+		res.Encrypted = true
+		res.EncryptionScheme = EncryptionScheme
+		res.Nonce = hex.EncodeToString(nonce)
+	}
+	res.SizeBytes = counter.n
+	res.SHA256 = fmt.Sprintf("%x", hasher.Sum(nil))
+	return res, nil
+}
 
-	// Get final file size
-	fileInfo, err := os.Stat(opts.OutputPath)
+// writeSymlink archives the link at path as a symlink entry, provided its
+// fully resolved target exists within printer_data. Absolute targets are
+// rewritten relative to the link so the archive restores under any root.
+// It reports false (and writes nothing) for broken or external links.
+func writeSymlink(aw archiveWriter, cleanRoot, resolvedRoot, path string, info os.FileInfo) (bool, error) {
+	target, err := os.Readlink(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat output file: %w", err)
+		return false, fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || !isWithinRoot(resolved, resolvedRoot) {
+		return false, nil
+	}
+
+	if filepath.IsAbs(target) {
+		// Both sides resolved, so the relative target stays inside the root.
+		linkDir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return false, nil
+		}
+		if target, err = filepath.Rel(linkDir, resolved); err != nil {
+			return false, nil
+		}
+	}
+
+	relPath, err := filepath.Rel(cleanRoot, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to calculate relative path: %w", err)
+	}
+
+	if err := aw.writeSymlink(filepath.ToSlash(relPath), filepath.ToSlash(target), info.ModTime()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// includeFile reports whether a file with the given base name should be archived.
+func (opts Options) includeFile(name string) bool {
+	if len(opts.IncludePatterns) == 0 && len(opts.ExcludePatterns) == 0 {
+		// Legacy default: only include .cfg files
+		if !strings.HasSuffix(name, ".cfg") {
+			return false
+		}
+		// Skip printer-*_*.cfg files (but keep printer.cfg)
+		if strings.HasPrefix(name, "printer-") && strings.Contains(name, "_") && name != "printer.cfg" {
+			return false
+		}
+		return true
+	}
+
+	if len(opts.IncludePatterns) > 0 && !matchAny(opts.IncludePatterns, name) {
+		return false
 	}
+	return !matchAny(opts.ExcludePatterns, name)
+}
+
+// changed reports whether info was modified after IncrementalSince (always
+// true for full backups).
+func (opts Options) changed(info os.FileInfo) bool {
+	return opts.IncrementalSince.IsZero() || info.ModTime().After(opts.IncrementalSince)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type countingWriter struct {
+	n int64
+}
 
-	return &Result{
-		ArchivePath: opts.OutputPath,
-		SizeBytes:   fileInfo.Size(),
-		SHA256:      fmt.Sprintf("%x", hasher.Sum(nil)),
-	}, nil
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
 }
 
 // isWithinRoot checks if path is within root (security check)
 func isWithinRoot(path, root string) bool {
-	cleanPath := filepath.Clean(path)
-	cleanRoot := filepath.Clean(root)
-	return strings.HasPrefix(cleanPath, cleanRoot)
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (relative path to content) under root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readEntries lists the tar.gz archive at path as entry name to link target
+// ("" for regular files), leaving out the manifest.
+func readEntries(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	entries := map[string]string{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == ManifestName {
+			continue
+		}
+		entries[h.Name] = h.Linkname
+	}
+}
+
+func TestCreateSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeTree(t, root, map[string]string{"config/printer.cfg": "[printer]\n"})
+	writeTree(t, outside, map[string]string{"secret.cfg": "token\n"})
+	if err := os.Symlink("printer.cfg", filepath.Join(root, "config", "internal.cfg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "config", "printer.cfg"), filepath.Join(root, "config", "absolute.cfg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.cfg"), filepath.Join(root, "config", "external.cfg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.cfg", filepath.Join(root, "config", "broken.cfg")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "backup.tar.gz")
+	res, err := Create(context.Background(), Options{
+		PrinterDataRoot: root,
+		IncludeConfig:   true,
+		OutputPath:      out,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	want := map[string]string{
+		"config/printer.cfg":  "",
+		"config/internal.cfg": "printer.cfg",
+		"config/absolute.cfg": "printer.cfg", // rewritten relative to the link
+	}
+	if got := readEntries(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive entries = %v; want %v", got, want)
+	}
+	if res.FileCount != len(want) {
+		t.Errorf("FileCount = %d; want %d", res.FileCount, len(want))
+	}
+	wantSkipped := []string{"config/broken.cfg", "config/external.cfg"}
+	if !reflect.DeepEqual(res.SkippedSymlinks, wantSkipped) {
+		t.Errorf("SkippedSymlinks = %v; want %v", res.SkippedSymlinks, wantSkipped)
+	}
+}
+
+func TestCreateSymlinksCountTowardMaxFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"config/printer.cfg": "[printer]\n"})
+	for _, name := range []string{"a.cfg", "b.cfg"} {
+		if err := os.Symlink("printer.cfg", filepath.Join(root, "config", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "backup.tar.gz")
+	_, err := Create(context.Background(), Options{
+		PrinterDataRoot: root,
+		IncludeConfig:   true,
+		OutputPath:      out,
+		MaxFiles:        2,
+	})
+	if err == nil || !strings.Contains(err.Error(), "limit of 2 files") {
+		t.Fatalf("Create error = %v; want file limit error", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("partial archive left at OutputPath (stat error %v)", err)
+	}
+}
+
+func TestCreateSizeLimitRemovesPartialArchive(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"config/a.cfg": strings.Repeat("a", 64),
+		"config/b.cfg": strings.Repeat("b", 64),
+	})
+
+	out := filepath.Join(t.TempDir(), "backup.tar.gz")
+	_, err := Create(context.Background(), Options{
+		PrinterDataRoot: root,
+		IncludeConfig:   true,
+		OutputPath:      out,
+		MaxSizeBytes:    100, // a.cfg fits, b.cfg doesn't
+	})
+	if err == nil || !strings.Contains(err.Error(), "exceeds limit of 100 bytes") {
+		t.Fatalf("Create error = %v; want size limit error", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("partial archive left at OutputPath (stat error %v)", err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"config/printer.cfg": "[printer]\n"})
+	key := make([]byte, 32)
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "targz", opts: Options{}, want: "application/gzip"},
+		{name: "zip", opts: Options{Format: FormatZip}, want: "application/zip"},
+		{name: "encrypted zip", opts: Options{Format: FormatZip, EncryptionKey: key}, want: EncryptedContentType},
+		{name: "encrypted targz", opts: Options{EncryptionKey: key}, want: EncryptedContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.PrinterDataRoot = root
+			opts.IncludeConfig = true
+			opts.OutputPath = filepath.Join(t.TempDir(), "backup")
+			if got := opts.ContentType(); got != tt.want {
+				t.Errorf("Options.ContentType() = %q; want %q", got, tt.want)
+			}
+			res, err := Create(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if got := res.ContentType(); got != tt.want {
+				t.Errorf("Result.ContentType() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EncryptionScheme identifies the format written when Options.EncryptionKey
+// is set. The archive is split into encChunkSize chunks, each sealed with
+// AES-256-GCM under nonce = prefix(7) || counter(4, big endian) || last(1),
+// so chunks can't be reordered, dropped or truncated undetected. The file
+// starts with encMagic and the random prefix.
+const EncryptionScheme = "aes-256-gcm-stream-v1"
+
+const (
+	encMagic     = "PCENC1"
+	encChunkSize = 64 << 10
+	encPrefixLen = 7
+)
+
+// ErrDecrypt means the ciphertext failed authentication: wrong key, or a
+// damaged or truncated archive.
+var ErrDecrypt = errors.New("backup decryption failed")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptWriter seals everything written to it onto w. Close must be called
+// to write the final chunk; it does not close w.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptWriter writes the header to w and returns the writer together
+// with the random nonce prefix.
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, []byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := make([]byte, encPrefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, nil, err
+	}
+	if _, err := w.Write(append([]byte(encMagic), prefix...)); err != nil {
+		return nil, nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encChunkSize)}, prefix, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so the final chunk
+		// (sealed by Close with the last flag) is never missing.
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("encrypted archive too large")
+	}
+	ct := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(ct)
+	return err
+}
+
+// Decrypt returns a reader of the plaintext tar.gz inside an archive
+// encrypted with key. Authentication failures surface from Read as
+// ErrDecrypt; nothing unauthenticated is ever returned.
+func Decrypt(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	header := make([]byte, len(encMagic)+encPrefixLen)
+	if _, err := io.ReadFull(br, header); err != nil || !bytes.HasPrefix(header, []byte(encMagic)) {
+		return nil, fmt.Errorf("%w: not an encrypted backup", ErrDecrypt)
+	}
+	return &decryptReader{r: br, aead: aead, prefix: header[len(encMagic):]}, nil
+}
+
+// IsEncrypted reports whether the archive starting with head (at least the
+// first 6 bytes) was written with an EncryptionKey.
+func IsEncrypted(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(encMagic))
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	ct := make([]byte, encChunkSize+d.aead.Overhead())
+	n, err := io.ReadFull(d.r, ct)
+	switch {
+	case err == io.EOF:
+		// The sealed last chunk is always present, even when empty.
+		return fmt.Errorf("%w: archive truncated", ErrDecrypt)
+	case err == io.ErrUnexpectedEOF:
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			d.done = true
+		}
+	}
+
+	pt, err := d.aead.Open(ct[:0], chunkNonce(d.prefix, d.counter, d.done), ct[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrDecrypt, d.counter)
+	}
+	d.counter++
+	d.buf = pt
+	return nil
+}
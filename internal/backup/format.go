@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Format selects the archive container.
+type Format string
+
+const (
+	FormatTarGz Format = "targz" // default
+	FormatZip   Format = "zip"   // for operators on Windows
+)
+
+// ParseFormat accepts "targz" (or "") and "zip".
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "", FormatTarGz:
+		return FormatTarGz, nil
+	case FormatZip:
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("unsupported backup format %q (targz|zip)", s)
+}
+
+// Extension is the file name suffix for archives in f.
+func (f Format) Extension() string {
+	if f == FormatZip {
+		return ".zip"
+	}
+	return ".tar.gz"
+}
+
+// ContentType is the MIME type of an unencrypted archive in f.
+func (f Format) ContentType() string {
+	if f == FormatZip {
+		return "application/zip"
+	}
+	return "application/gzip"
+}
+
+// EncryptedContentType is the MIME type of an encrypted archive, whatever
+// its format: the ciphertext is neither gzip nor zip.
+const EncryptedContentType = "application/octet-stream"
+
+// ContentType is the MIME type of the archive Create or CreateStream
+// writes for o, known before it is built (for streamed uploads).
+func (o Options) ContentType() string {
+	if len(o.EncryptionKey) > 0 {
+		return EncryptedContentType
+	}
+	f, err := ParseFormat(string(o.Format))
+	if err != nil {
+		f = FormatTarGz
+	}
+	return f.ContentType()
+}
+
+// ContentType is the MIME type of the archive r describes.
+func (r *Result) ContentType() string {
+	if r.Encrypted {
+		return EncryptedContentType
+	}
+	return r.Format.ContentType()
+}
+
+// archiveWriter adds entries to an archive in one of the Formats. Names use
+// forward slashes; permissions are fixed (0644 files, 0777 links).
+type archiveWriter interface {
+	writeFile(name string, size int64, modTime time.Time, r io.Reader) error
+	writeSymlink(name, target string, modTime time.Time) error
+	Close() error
+}
+
+func newArchiveWriter(f Format, w io.Writer) archiveWriter {
+	if f == FormatZip {
+		return &zipWriter{zw: zip.NewWriter(w)}
+	}
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) writeFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	// Create minimal tar header - use GNU format which is more lenient
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644, // Simple fixed permissions
+		ModTime:  modTime,
+		Format:   tar.FormatGNU, // GNU format is more permissive than PAX
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	return copyExact(t.tw, r, name, size)
+}
+
+func (t *tarGzWriter) writeSymlink(name, target string, modTime time.Time) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  modTime,
+		Format:   tar.FormatGNU,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	return nil
+}
+
+func (t *tarGzWriter) Close() error {
+	// Close writers to flush buffers
+	if err := t.tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := t.gz.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) writeFile(name string, size int64, modTime time.Time, r io.Reader) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	fh.SetMode(0644)
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return fmt.Errorf("failed to write zip header: %w", err)
+	}
+	return copyExact(w, r, name, size)
+}
+
+// writeSymlink stores the link the way Info-ZIP does: symlink mode bits
+// and the target as the entry's content.
+func (z *zipWriter) writeSymlink(name, target string, modTime time.Time) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime}
+	fh.SetMode(os.ModeSymlink | 0777)
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return fmt.Errorf("failed to write zip header: %w", err)
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	if err := z.zw.Close(); err != nil {
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	return nil
+}
+
+// copyExact copies exactly size bytes of r to w, failing if the file
+// changed size while being archived.
+func copyExact(w io.Writer, r io.Reader, name string, size int64) error {
+	// Use LimitReader to ensure we don't write more than size
+	written, err := io.Copy(w, io.LimitReader(r, size))
+	if err != nil {
+		return fmt.Errorf("failed to write file %s to archive: %w", name, err)
+	}
+	// Verify we wrote the expected amount
+	if written != size {
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, wrote %d bytes", name, size, written)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// ManifestName is the archive entry, written last, that lists the files in
+// the archive.
+const ManifestName = ".backup-manifest.json"
+
+// Manifest describes an archive's contents. IncrementalSince is nil for a
+// full backup.
+type Manifest struct {
+	Version          int        `json:"version"`
+	CreatedAt        time.Time  `json:"created_at"`
+	IncrementalSince *time.Time `json:"incremental_since,omitempty"`
+	Files            []string   `json:"files"`
+}
+
+func writeManifest(aw archiveWriter, opts Options, files []string) error {
+	m := Manifest{Version: 1, CreatedAt: time.Now().UTC(), Files: files}
+	if m.Files == nil {
+		m.Files = []string{}
+	}
+	if !opts.IncrementalSince.IsZero() {
+		since := opts.IncrementalSince.UTC()
+		m.IncrementalSince = &since
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return aw.writeFile(ManifestName, int64(len(b)), m.CreatedAt, bytes.NewReader(b))
+}
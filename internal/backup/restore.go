@@ -0,0 +1,243 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Restore extracts archives into printerDataRoot in the order given: a full
+// backup first, then its incrementals oldest to newest, so later versions
+// of a file overwrite earlier ones. key decrypts encrypted archives and may
+// be nil otherwise. Entries that would land outside printerDataRoot, and
+// symlinks pointing outside it, are rejected.
+func Restore(printerDataRoot string, key []byte, archivePaths ...string) error {
+	root := filepath.Clean(printerDataRoot)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create printer_data_root: %w", err)
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve printer_data_root: %w", err)
+	}
+
+	for _, p := range archivePaths {
+		if err := restoreArchive(resolvedRoot, key, p); err != nil {
+			return fmt.Errorf("restore %s: %w", filepath.Base(p), err)
+		}
+	}
+	return nil
+}
+
+func restoreArchive(root string, key []byte, archivePath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(encMagic))
+	if isZip(head) {
+		return restoreZip(root, f)
+	}
+
+	var r io.Reader = br
+	if IsEncrypted(head) {
+		if key == nil {
+			return errors.New("archive is encrypted but no key was given")
+		}
+		if r, err = Decrypt(r, key); err != nil {
+			return err
+		}
+		// Zip needs random access, so an encrypted one is decrypted to a
+		// temporary file first.
+		dr := bufio.NewReader(r)
+		if head, _ := dr.Peek(len(zipMagic)); isZip(head) {
+			return restoreDecryptedZip(root, dr)
+		}
+		r = dr
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: gzip: %v", ErrCorruptArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: tar: %v", ErrCorruptArchive, err)
+		}
+		if header.Name == ManifestName {
+			continue
+		}
+		if err := restoreEntry(root, header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func restoreEntry(root string, header *tar.Header, r io.Reader) error {
+	target := filepath.Join(root, filepath.FromSlash(header.Name))
+	if filepath.IsAbs(filepath.FromSlash(header.Name)) || !isWithinRoot(target, root) || target == root {
+		return fmt.Errorf("entry %q escapes printer_data", header.Name)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0755)
+	case tar.TypeReg, tar.TypeSymlink:
+	default:
+		return nil
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// An existing symlinked directory must not redirect the write elsewhere.
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil || !isWithinRoot(resolvedDir, root) {
+		return fmt.Errorf("entry %q: directory resolves outside printer_data", header.Name)
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		link := filepath.FromSlash(header.Linkname)
+		if filepath.IsAbs(link) || !isWithinRoot(filepath.Join(dir, link), root) {
+			return fmt.Errorf("symlink %q points outside printer_data", header.Name)
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+		// The check above is lexical; ".." through a link already on disk
+		// can still lead out, so look at where the new link really goes.
+		if linkEscapes(root, resolvedDir, target, link) {
+			os.Remove(target)
+			return fmt.Errorf("symlink %q points outside printer_data", header.Name)
+		}
+		return nil
+	}
+
+	// Write to a temp file and rename, so an interrupted restore never
+	// leaves a half-written config behind.
+	tmp, err := os.CreateTemp(dir, ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: entry %s: %v", ErrCorruptArchive, header.Name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// restoreZip extracts a zip archive, mapping its entries onto the same
+// checks restoreEntry applies to tar entries.
+func restoreZip(root string, f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, st.Size())
+	if err != nil {
+		return fmt.Errorf("%w: zip: %v", ErrCorruptArchive, err)
+	}
+
+	for _, zf := range zr.File {
+		if zf.Name == ManifestName {
+			continue
+		}
+		header := &tar.Header{Name: zf.Name, ModTime: zf.Modified, Typeflag: tar.TypeReg}
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("%w: zip entry %s: %v", ErrCorruptArchive, zf.Name, err)
+		}
+		var r io.Reader = rc
+		switch mode := zf.Mode(); {
+		case mode.IsDir():
+			header.Typeflag = tar.TypeDir
+		case mode&os.ModeSymlink != 0:
+			target, err := io.ReadAll(io.LimitReader(rc, 4096))
+			if err != nil {
+				rc.Close()
+				return fmt.Errorf("%w: zip entry %s: %v", ErrCorruptArchive, zf.Name, err)
+			}
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = string(target)
+		}
+		err = restoreEntry(root, header, r)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreDecryptedZip(root string, r io.Reader) error {
+	tmp, err := os.CreateTemp("", "restore-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	return restoreZip(root, tmp)
+}
+
+// linkEscapes reports whether the symlink at path, with target link and
+// parent directory dir (already resolved), leads outside root. A dangling
+// link is followed component by component as far as the tree exists, so
+// one that would escape once its target is created is caught too.
+func linkEscapes(root, dir, path, link string) bool {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return !isWithinRoot(resolved, root)
+	}
+	cur := dir
+	for _, part := range strings.Split(link, string(filepath.Separator)) {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+		default:
+			cur = filepath.Join(cur, part)
+			if resolved, err := filepath.EvalSymlinks(cur); err == nil {
+				cur = resolved
+			}
+		}
+		if !isWithinRoot(cur, root) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTarGz writes headers (with no file content) as a tar.gz archive.
+func writeTarGz(t *testing.T, path string, headers ...*tar.Header) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, h := range headers {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreRejectsSymlinkEscapingThroughLink(t *testing.T) {
+	for _, existing := range []bool{true, false} {
+		name := "dangling"
+		if existing {
+			name = "existing target"
+		}
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			root := filepath.Join(parent, "printer_data")
+			if existing {
+				writeTree(t, parent, map[string]string{"secret.cfg": "token\n"})
+			}
+
+			// "up" stays inside printer_data, but "evil" climbs out through
+			// it: lexically config/sub/up/../secret.cfg is config/sub/secret.cfg.
+			archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+			writeTarGz(t, archive,
+				&tar.Header{Name: "config/sub/up", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+				&tar.Header{Name: "config/sub/evil", Typeflag: tar.TypeSymlink, Linkname: "up/../secret.cfg"},
+			)
+
+			err := Restore(root, nil, archive)
+			if err == nil || !strings.Contains(err.Error(), "points outside printer_data") {
+				t.Fatalf("Restore error = %v; want symlink escape error", err)
+			}
+			if _, err := os.Lstat(filepath.Join(root, "config", "sub", "evil")); !os.IsNotExist(err) {
+				t.Errorf("escaping symlink left behind (lstat error %v)", err)
+			}
+		})
+	}
+}
+
+func TestRestoreSymlinkWithinRoot(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"config/printer.cfg": "[printer]\n"})
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	writeTarGz(t, archive,
+		&tar.Header{Name: "config/sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		&tar.Header{Name: "config/sub/link.cfg", Typeflag: tar.TypeSymlink, Linkname: "up/printer.cfg"},
+	)
+	if err := Restore(root, nil, archive); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(root, "config", "sub", "link.cfg"))
+	if err != nil || string(b) != "[printer]\n" {
+		t.Errorf("link.cfg = %q, %v; want printer.cfg contents", b, err)
+	}
+}
@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Verify errors; callers can tell a wrong archive from a damaged one with
+// errors.Is.
+var (
+	ErrHashMismatch   = errors.New("backup sha256 mismatch")
+	ErrCorruptArchive = errors.New("backup archive is corrupt")
+)
+
+// VerifyResult describes an archive that passed Verify.
+type VerifyResult struct {
+	SHA256            string
+	SizeBytes         int64 // compressed, as stored
+	FileCount         int   // regular files and symlinks, excluding the manifest
+	UncompressedBytes int64 // sum of file sizes
+
+	// Encrypted archives are only hashed; to check their structure, pass
+	// the output of Decrypt to VerifyReader.
+	Encrypted bool
+}
+
+// Verify reads the archive at archivePath, recomputing its SHA256 and
+// reading every entry to check the gzip/tar (or zip) structure. An empty
+// expectedSHA256 skips the hash comparison.
+func Verify(archivePath, expectedSHA256 string) (*VerifyResult, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, len(zipMagic))
+	if n, _ := f.ReadAt(head, 0); n == len(head) && isZip(head) {
+		return verifyZip(f, expectedSHA256)
+	}
+	return VerifyReader(f, expectedSHA256)
+}
+
+// VerifyReader is Verify for a tar.gz read from r, e.g. a download. Zip
+// archives need random access and are only supported by Verify.
+func VerifyReader(r io.Reader, expectedSHA256 string) (*VerifyResult, error) {
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	tee := io.TeeReader(r, io.MultiWriter(hasher, counter))
+
+	res := &VerifyResult{}
+	br := bufio.NewReader(tee)
+	var structErr error
+	if head, _ := br.Peek(len(encMagic)); IsEncrypted(head) {
+		res.Encrypted = true
+	} else {
+		structErr = readArchive(br, res)
+	}
+
+	// Hash the whole input even if the structure check stopped early, so a
+	// mismatch is reported as such rather than as corruption.
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	res.SHA256 = fmt.Sprintf("%x", hasher.Sum(nil))
+	res.SizeBytes = counter.n
+
+	if expectedSHA256 != "" && !strings.EqualFold(res.SHA256, expectedSHA256) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedSHA256, res.SHA256)
+	}
+	if structErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptArchive, structErr)
+	}
+	return res, nil
+}
+
+// readArchive walks every tar entry in the gzip stream r, filling in the
+// file counts in res.
+func readArchive(r io.Reader, res *VerifyResult) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+
+		switch {
+		case header.Name == ManifestName:
+		case header.Typeflag == tar.TypeReg, header.Typeflag == tar.TypeSymlink:
+			res.FileCount++
+		}
+
+		// Reading the data checks the entry's size against the header.
+		n, err := io.Copy(io.Discard, tr)
+		if err != nil {
+			return fmt.Errorf("tar entry %s: %w", header.Name, err)
+		}
+		if header.Name != ManifestName {
+			res.UncompressedBytes += n
+		}
+	}
+
+	// Drain the gzip stream so its trailing checksum is verified too.
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+	return gz.Close()
+}
+
+// zipMagic starts every local file header, and so every non-empty zip.
+const zipMagic = "PK\x03\x04"
+
+func isZip(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(zipMagic))
+}
+
+// verifyZip hashes f, then opens every entry so their CRCs are checked.
+func verifyZip(f *os.File, expectedSHA256 string) (*VerifyResult, error) {
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, io.NewSectionReader(f, 0, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	res := &VerifyResult{SHA256: fmt.Sprintf("%x", hasher.Sum(nil)), SizeBytes: size}
+	if expectedSHA256 != "" && !strings.EqualFold(res.SHA256, expectedSHA256) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedSHA256, res.SHA256)
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: zip: %v", ErrCorruptArchive, err)
+	}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: zip entry %s: %v", ErrCorruptArchive, zf.Name, err)
+		}
+		n, err := io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: zip entry %s: %v", ErrCorruptArchive, zf.Name, err)
+		}
+		if zf.Name == ManifestName || zf.FileInfo().IsDir() {
+			continue
+		}
+		res.FileCount++
+		if zf.Mode()&os.ModeSymlink == 0 {
+			res.UncompressedBytes += n
+		}
+	}
+	return res, nil
+}
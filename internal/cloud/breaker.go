@@ -0,0 +1,95 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the cloud while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("cloud: circuit open")
+
+// circuitBreaker stops API calls after threshold consecutive failures
+// (connection errors, 429 and 5xx, after retries) so a cloud that is down
+// isn't hammered by every loop. Once cooldown has passed a single probe
+// request goes through: success closes the circuit, failure re-opens it for
+// another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool // the post-cooldown probe is in flight
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns ErrCircuitOpen (with the time left) unless a request may be
+// sent now. Every allowed request must be followed by record.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	if left := b.cooldown - time.Since(b.openedAt); left > 0 {
+		return fmt.Errorf("%w (retry in %s)", ErrCircuitOpen, left.Round(time.Second))
+	}
+	if b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record feeds the outcome of an allowed request back. A request abandoned
+// because ctx ended says nothing about the cloud and only frees the probe.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbe := b.probing
+	b.probing = false
+	switch {
+	case ctx.Err() != nil:
+	case !IsOutage(err):
+		b.failures, b.open = 0, false
+	default:
+		b.failures++
+		if wasProbe || (!b.open && b.failures >= b.threshold) {
+			b.open, b.openedAt = true, time.Now()
+		}
+	}
+}
+
+// isOpen reports whether the circuit is open, including after the cooldown
+// until a probe succeeds.
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// IsOutage reports whether err means the cloud itself is unavailable (no
+// connection, 5xx or 429), as opposed to rejecting this particular request.
+func IsOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return true
+	}
+	return IsServerError(err) || IsRateLimited(err)
+}
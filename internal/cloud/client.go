@@ -2,7 +2,11 @@ package cloud
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,18 +15,48 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"printer-connector/internal/util"
 )
 
 type Client struct {
-	baseURL         string
+	// baseURLs are tried in order on connection failure; active is the index
+	// of the last endpoint that worked.
+	baseURLs []string
+	active   atomic.Int32
+
+	// Credentials can be replaced by SetCredentials while requests are in
+	// flight (re-pairing).
+	credMu          sync.RWMutex
 	connectorID     string
 	connectorSecret string
-	httpClient      *http.Client
-	logger          *slog.Logger
-	userAgent       string
+
+	// unauthorized counts consecutive 401 responses; any 2xx resets it.
+	unauthorized atomic.Int32
+
+	httpClient  *http.Client
+	logger      *slog.Logger
+	userAgent   string
+	compress    bool
+	maxAttempts int
+	signingKey  []byte
+	limiter     *rateLimiter
+	breaker     *circuitBreaker
+	slowRequest time.Duration
+
+	// uploadClient is for presigned uploads and downloads, which would never
+	// fit in the API timeout.
+	uploadClient *http.Client
+
+	// noBatchComplete is set once the batch completion endpoint 404s, so
+	// later batches go straight to per-command completion.
+	noBatchComplete atomic.Bool
 }
 
 type Options struct {
@@ -31,32 +65,191 @@ type Options struct {
 	ConnectorSecret string
 	Logger          *slog.Logger
 	UserAgent       string
+
+	// BaseURLs lists failover endpoints, tried in order after BaseURL.
+	BaseURLs []string
+
+	// CompressRequests gzips JSON request bodies larger than
+	// compressThreshold and sends them with Content-Encoding: gzip.
+	CompressRequests bool
+
+	// MaxAttempts bounds retries of transient failures in API calls (default 3).
+	MaxAttempts int
+
+	// RateLimit caps API requests per second, allowing bursts of RateBurst
+	// (defaults 10/s, burst 20). Retries count against the limit too.
+	RateLimit float64
+	RateBurst int
+
+	// After CircuitBreakerThreshold consecutive failed API calls (default 5)
+	// calls fail fast with ErrCircuitOpen for CircuitBreakerCooldown
+	// (default 30s), then a single probe is let through.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+
+	// Timeouts; zero values keep the defaults (5s total, 2s dial, 3s TLS).
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+
+	// SlowRequestThreshold: API attempts taking longer are logged as a
+	// warning (default 2s).
+	SlowRequestThreshold time.Duration
+
+	// UploadTimeout bounds one presigned upload attempt or download
+	// (default 30m).
+	// Uploads get their own transport so they don't hold up API calls.
+	UploadTimeout time.Duration
+
+	// SigningKey, when set, HMAC-signs every API request (see sign).
+	SigningKey string
+
+	// Optional mutual TLS. ClientCertPath and ClientKeyPath must be set
+	// together; CACertPath replaces the system roots for the cloud server.
+	// None of the TLS options apply to presigned storage URLs.
+	ClientCertPath string
+	ClientKeyPath  string
+	CACertPath     string
+
+	// PinnedCertSHA256, when set, rejects servers whose leaf certificate
+	// (SPKI or whole cert) SHA-256 differs. Hex or base64.
+	PinnedCertSHA256 string
+}
+
+// requestIDHeader carries a random per-call ID for correlating connector
+// and cloud logs.
+const requestIDHeader = "X-Request-Id"
+
+func newRequestID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-func New(opts Options) *Client {
+// idempotencyKeyHeader, when set by a caller of doJSON, lets the cloud
+// dedupe repeated requests and makes POSTs safe to retry on 5xx.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// compressThreshold is the smallest body worth gzipping; below it the gzip
+// header overhead outweighs the savings.
+const compressThreshold = 1024
+
+func New(opts Options) (*Client, error) {
+	tlsConfig, err := loadTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := durationOr(opts.Timeout, 5*time.Second)
 	transport := &http.Transport{
-		DialContext:           (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
-		TLSHandshakeTimeout:   3 * time.Second,
-		ResponseHeaderTimeout: 5 * time.Second,
+		DialContext:           (&net.Dialer{Timeout: durationOr(opts.DialTimeout, 2*time.Second)}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   durationOr(opts.TLSHandshakeTimeout, 3*time.Second),
+		ResponseHeaderTimeout: timeout,
 		IdleConnTimeout:       30 * time.Second,
 	}
 
+	// Streaming a large body wants bigger buffers than JSON calls, and
+	// storage may take a while to answer once the last byte is in. Presigned
+	// URLs point at object storage, not the cloud, so they get the system
+	// roots: the client cert, CACertPath and the pin are for the cloud only.
+	uploadTransport := &http.Transport{
+		DialContext:           transport.DialContext,
+		TLSHandshakeTimeout:   transport.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       30 * time.Second,
+		WriteBufferSize:       256 << 10,
+		ReadBufferSize:        64 << 10,
+	}
+
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	rate, burst := opts.RateLimit, opts.RateBurst
+	if rate <= 0 {
+		rate = 10
+	}
+	if burst <= 0 {
+		burst = 20
+	}
+
+	threshold := opts.CircuitBreakerThreshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+
+	var baseURLs []string
+	for _, u := range append([]string{opts.BaseURL}, opts.BaseURLs...) {
+		u = strings.TrimRight(u, "/")
+		if u != "" && !slices.Contains(baseURLs, u) {
+			baseURLs = append(baseURLs, u)
+		}
+	}
+	if len(baseURLs) == 0 {
+		return nil, errors.New("no cloud base URL configured")
+	}
+
 	return &Client{
-		baseURL:         strings.TrimRight(opts.BaseURL, "/"),
+		baseURLs:        baseURLs,
 		connectorID:     opts.ConnectorID,
 		connectorSecret: opts.ConnectorSecret,
 		httpClient: &http.Client{
-			Timeout:   5 * time.Second,
+			Timeout:   timeout,
 			Transport: transport,
 		},
-		logger:    opts.Logger,
-		userAgent: opts.UserAgent,
+		logger:      opts.Logger,
+		userAgent:   opts.UserAgent,
+		compress:    opts.CompressRequests,
+		maxAttempts: maxAttempts,
+		signingKey:  []byte(opts.SigningKey),
+		limiter:     newRateLimiter(rate, burst),
+		breaker:     newCircuitBreaker(threshold, durationOr(opts.CircuitBreakerCooldown, 30*time.Second)),
+		slowRequest: durationOr(opts.SlowRequestThreshold, 2*time.Second),
+
+		uploadClient: &http.Client{
+			Timeout:   durationOr(opts.UploadTimeout, 30*time.Minute),
+			Transport: uploadTransport,
+		},
+	}, nil
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
 	}
+	return def
 }
 
 func (c *Client) SetCredentials(id, secret string) {
+	c.credMu.Lock()
 	c.connectorID = id
 	c.connectorSecret = secret
+	c.credMu.Unlock()
+	c.unauthorized.Store(0)
+}
+
+// ConnectorID returns the connector ID requests are currently made as.
+func (c *Client) ConnectorID() string {
+	c.credMu.RLock()
+	defer c.credMu.RUnlock()
+	return c.connectorID
+}
+
+// CircuitOpen reports whether the circuit breaker is open: API calls fail
+// fast with ErrCircuitOpen, apart from a probe once per cooldown, until one
+// succeeds.
+func (c *Client) CircuitOpen() bool {
+	return c.breaker.isOpen()
+}
+
+// UnauthorizedStreak is the number of consecutive 401 responses, i.e. how
+// long the credentials have been failing.
+func (c *Client) UnauthorizedStreak() int {
+	return int(c.unauthorized.Load())
 }
 
 func (c *Client) Register(ctx context.Context, req RegisterRequest) (*RegisterResponse, error) {
@@ -67,23 +260,108 @@ func (c *Client) Register(ctx context.Context, req RegisterRequest) (*RegisterRe
 	return &out, nil
 }
 
-func (c *Client) Heartbeat(ctx context.Context, hb HeartbeatRequest) error {
-	path := fmt.Sprintf("/api/v1/connectors/%s/heartbeat", url.PathEscape(c.connectorID))
-	return c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), hb, nil)
+func (c *Client) Heartbeat(ctx context.Context, hb HeartbeatRequest) (HeartbeatResponse, error) {
+	path := fmt.Sprintf("/api/v1/connectors/%s/heartbeat", url.PathEscape(c.ConnectorID()))
+	var out HeartbeatResponse
+	err := c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), hb, &out)
+	return out, err
+}
+
+// PostEvent reports a printer state transition to the cloud.
+func (c *Client) PostEvent(ctx context.Context, ev Event) error {
+	path := fmt.Sprintf("/api/v1/connectors/%s/events", url.PathEscape(c.ConnectorID()))
+	return c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), ev, nil)
+}
+
+// Deregister tells the cloud this connector is being decommissioned, so it
+// is removed instead of showing up as offline.
+func (c *Client) Deregister(ctx context.Context, connectorID string) error {
+	path := fmt.Sprintf("/api/v1/connectors/%s", url.PathEscape(connectorID))
+	return c.doJSON(ctx, http.MethodDelete, path, c.authHeaders(), nil, nil)
 }
 
-func (c *Client) GetCommands(ctx context.Context, connectorID string, limit int) ([]Command, error) {
+// GetCommands fetches one page of pending commands, starting at cursor
+// (empty for the first page). Servers that predate pagination return a bare
+// array, which is treated as a single, final page.
+func (c *Client) GetCommands(ctx context.Context, connectorID string, limit int, cursor string) (CommandsPage, error) {
 	path := fmt.Sprintf("/api/v1/connectors/%s/commands?limit=%d", url.PathEscape(connectorID), limit)
-	var out []Command
-	if err := c.doJSON(ctx, http.MethodGet, path, c.authHeaders(), nil, &out); err != nil {
-		return nil, err
+	if cursor != "" {
+		path += "&cursor=" + url.QueryEscape(cursor)
 	}
-	return out, nil
+	var raw json.RawMessage
+	if err := c.doJSON(ctx, http.MethodGet, path, c.authHeaders(), nil, &raw); err != nil {
+		return CommandsPage{}, err
+	}
+
+	var page CommandsPage
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0:
+		return page, nil
+	case raw[0] == '[':
+		err := json.Unmarshal(raw, &page.Commands)
+		return page, err
+	}
+	err := json.Unmarshal(raw, &page)
+	return page, err
 }
 
+// CompleteCommand reports a command's outcome. The Idempotency-Key is fixed
+// per (command, status), so a retry after a lost response is recognisable as
+// the same completion while a conflicting one is not.
 func (c *Client) CompleteCommand(ctx context.Context, commandID StringOrNumber, req CommandCompleteRequest) error {
 	path := fmt.Sprintf("/api/v1/commands/%s/complete", url.PathEscape(commandID.String()))
-	return c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), req, nil)
+	headers := c.authHeaders()
+	headers[idempotencyKeyHeader] = fmt.Sprintf("command-%s-%s", commandID.String(), req.Status)
+	return c.doJSON(ctx, http.MethodPost, path, headers, req, nil)
+}
+
+// CompleteCommands reports several outcomes in one request. If the batch
+// fails it falls back to CompleteCommand for each, so one bad request
+// doesn't lose every outcome; against a cloud without the batch endpoint
+// (404) it also remembers not to try the batch again.
+func (c *Client) CompleteCommands(ctx context.Context, completions []BatchCompletion) error {
+	if len(completions) == 0 {
+		return nil
+	}
+	if !c.noBatchComplete.Load() {
+		req := BatchCompleteRequest{Completions: make([]BatchCompletion, len(completions))}
+		keys := sha256.New()
+		for i, bc := range completions {
+			bc.IdempotencyKey = fmt.Sprintf("command-%s-%s", bc.CommandID.String(), bc.Status)
+			req.Completions[i] = bc
+			io.WriteString(keys, bc.IdempotencyKey+"\n")
+		}
+		headers := c.authHeaders()
+		headers[idempotencyKeyHeader] = fmt.Sprintf("commands-batch-%x", keys.Sum(nil)[:16])
+		err := c.doJSON(ctx, http.MethodPost, "/api/v1/commands/complete_batch", headers, req, nil)
+		switch {
+		case err == nil:
+			return nil
+		case IsNotFound(err):
+			c.logger.Info("cloud has no batch completion endpoint; completing commands one by one")
+			c.noBatchComplete.Store(true)
+		default:
+			c.logger.Warn("batch completion failed; completing commands one by one", "count", len(completions), "error", err)
+		}
+	}
+
+	var errs []error
+	for _, bc := range completions {
+		if err := c.CompleteCommand(ctx, bc.CommandID, bc.CommandCompleteRequest); err != nil {
+			errs = append(errs, fmt.Errorf("command %s: %w", bc.CommandID.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AckCommand tells the cloud a command has been picked up and is now
+// in_progress, so the dashboard can show it as running before it completes.
+func (c *Client) AckCommand(ctx context.Context, commandID StringOrNumber) error {
+	path := fmt.Sprintf("/api/v1/commands/%s/ack", url.PathEscape(commandID.String()))
+	headers := c.authHeaders()
+	headers[idempotencyKeyHeader] = fmt.Sprintf("command-%s-ack", commandID.String())
+	return c.doJSON(ctx, http.MethodPost, path, headers, CommandAckRequest{Status: "in_progress"}, nil)
 }
 
 func (c *Client) PushSnapshots(ctx context.Context, req SnapshotsBatchRequest) (*SnapshotsBatchResponse, error) {
@@ -94,96 +372,287 @@ func (c *Client) PushSnapshots(ctx context.Context, req SnapshotsBatchRequest) (
 	return &out, nil
 }
 
+// RequestBackupUpload registers a backup with the cloud and returns a
+// presigned URL to upload the archive to.
+func (c *Client) RequestBackupUpload(ctx context.Context, connectorID string, meta BackupMeta) (*UploadTicket, error) {
+	path := fmt.Sprintf("/api/v1/connectors/%s/backups", url.PathEscape(connectorID))
+	var out UploadTicket
+	if err := c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), meta, &out); err != nil {
+		return nil, err
+	}
+	if out.UploadURL == "" && out.Multipart == nil {
+		return nil, errors.New("cloud: backup upload ticket has no upload_url")
+	}
+	return &out, nil
+}
+
 func (c *Client) authHeaders() map[string]string {
+	c.credMu.RLock()
+	defer c.credMu.RUnlock()
 	return map[string]string{
 		"Authorization":  "Bearer " + c.connectorSecret,
 		"X-Connector-Id": c.connectorID,
 	}
 }
 
+// doJSON sends an API request through the circuit breaker.
 func (c *Client) doJSON(ctx context.Context, method, path string, headers map[string]string, body any, out any) error {
-	full := c.baseURL + path
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	err := c.doJSONRetry(ctx, method, path, headers, body, out)
+	c.breaker.record(ctx, err)
+	return err
+}
 
-	var reqBody io.Reader
+// doJSONRetry sends an API request, retrying transient failures and failing
+// over between endpoints.
+func (c *Client) doJSONRetry(ctx context.Context, method, path string, headers map[string]string, body any, out any) error {
+	var payload []byte
+	gzipped := false
 	if body != nil {
 		b, err := json.Marshal(body)
 		if err != nil {
 			return err
 		}
-		reqBody = bytes.NewReader(b)
+		if c.compress && len(b) > compressThreshold {
+			if b, err = gzipBytes(b); err != nil {
+				return err
+			}
+			gzipped = true
+		}
+		payload = b
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, full, reqBody)
+	// Only 429s (not processed) and connection errors are retried for
+	// non-idempotent requests; retrying a POST on 5xx could execute it twice
+	// unless the caller set an Idempotency-Key for the server to dedupe on.
+	idempotent := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete || method == http.MethodHead ||
+		headers[idempotencyKeyHeader] != ""
+	bo := util.NewBackoff(500*time.Millisecond, 5*time.Second)
+
+	// One request ID covers all attempts so both sides can be grepped for it.
+	reqID, err := newRequestID()
 	if err != nil {
 		return err
 	}
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[requestIDHeader] = reqID
+
+	// Failing over to another endpoint doesn't use up an attempt.
+	failovers := 0
+
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+		idx := c.active.Load()
+		start := time.Now()
+		status, header, respB, err := c.doOnce(ctx, c.baseURLs[idx], method, path, h, body != nil, payload, gzipped)
+		if took := time.Since(start); took > c.slowRequest {
+			// status is 0 when no response arrived.
+			c.logger.Warn("slow cloud request",
+				"method", method,
+				"path", path,
+				"status", status,
+				"duration_ms", took.Milliseconds(),
+				"request_id", reqID,
+				"attempt", attempt,
+			)
+		}
+		if err == nil {
+			c.logger.Debug("cloud response", "method", method, "path", path, "status", status, "request_id", reqID)
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return err
+			}
+			if failovers < len(c.baseURLs)-1 {
+				failovers++
+				c.failover(idx, err)
+				continue
+			}
+			// A pin mismatch won't fix itself on retry.
+			if errors.Is(err, ErrCertPinMismatch) || attempt >= c.maxAttempts+failovers {
+				return err
+			}
+			wait, _ = bo.Next()
+		case status < 200 || status >= 300:
+			msg := strings.TrimSpace(string(respB))
+			if msg == "" {
+				msg = http.StatusText(status)
+			}
+			err = &HTTPError{StatusCode: status, Body: msg, RequestID: reqID}
+			if status == http.StatusUnauthorized {
+				c.unauthorized.Add(1)
+			}
+
+			retryable := status == http.StatusTooManyRequests || (status >= 500 && idempotent)
+			if !retryable || attempt >= c.maxAttempts {
+				return err
+			}
+			wait, _ = bo.Next()
+			if ra, ok := parseRetryAfter(header.Get("Retry-After")); ok && status == http.StatusTooManyRequests {
+				wait = ra
+			}
+		default:
+			c.unauthorized.Store(0)
+			if out == nil {
+				return nil
+			}
+			if len(respB) == 0 {
+				return errors.New("cloud: empty response body")
+			}
+			if err := json.Unmarshal(respB, out); err != nil {
+				return fmt.Errorf("cloud: invalid json: %w", err)
+			}
+			return nil
+		}
+
+		c.logger.Debug("retrying cloud request", "method", method, "path", path, "request_id", reqID, "attempt", attempt, "wait", wait, "error", err)
+		if err := util.SleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// doOnce performs a single HTTP attempt and returns the status, headers and
+// (size-limited) response body.
+func (c *Client) doOnce(ctx context.Context, base, method, path string, headers map[string]string, hasBody bool, payload []byte, gzipped bool) (int, http.Header, []byte, error) {
+	var reqBody io.Reader
+	if hasBody {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+path, reqBody)
+	if err != nil {
+		return 0, nil, nil, err
+	}
 
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", c.userAgent)
-	if body != nil {
+	if hasBody {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	// Signed per attempt so retries carry a fresh timestamp and nonce.
+	if err := c.sign(req, path, payload); err != nil {
+		return 0, nil, nil, err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	respB, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, resp.Header, respB, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		msg := strings.TrimSpace(string(respB))
-		if msg == "" {
-			msg = resp.Status
-		}
-		return fmt.Errorf("cloud http %d: %s", resp.StatusCode, msg)
+// failover moves to the endpoint after from, unless a concurrent request
+// already moved on.
+func (c *Client) failover(from int32, cause error) {
+	to := (from + 1) % int32(len(c.baseURLs))
+	if c.active.CompareAndSwap(from, to) {
+		c.logger.Warn("cloud endpoint unreachable, failing over",
+			"from", c.baseURLs[from],
+			"to", c.baseURLs[to],
+			"error", cause,
+		)
 	}
+}
 
-	if out == nil {
-		return nil
+// maxRetryAfter caps how long a server-provided Retry-After can stall a call.
+const maxRetryAfter = 60 * time.Second
+
+// parseRetryAfter parses a Retry-After header given as delay-seconds or an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
 	}
-	if len(respB) == 0 {
-		return errors.New("cloud: empty response body")
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
 	}
-	if err := json.Unmarshal(respB, out); err != nil {
-		return fmt.Errorf("cloud: invalid json: %w", err)
+	if d < 0 {
+		d = 0
 	}
-	return nil
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
 }
 
-// UploadBackup uploads a backup archive file to a presigned URL via HTTP PUT.
-// This is used for direct upload to cloud storage (S3, GCS, etc).
-func (c *Client) UploadBackup(ctx context.Context, presignedURL, filePath string) error {
-	// Open backup file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
 	}
-	defer file.Close()
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	// Get file size for Content-Length
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat backup file: %w", err)
+// UploadBackupStream uploads a backup archive read from r to a presigned URL.
+// size is sent as Content-Length when known; pass -1 for a chunked upload of
+// unknown length (e.g. when r is fed from backup.CreateStream).
+func (c *Client) UploadBackupStream(ctx context.Context, presignedURL string, r io.Reader, size int64, contentType string) error {
+	if err := c.uploadPresigned(ctx, presignedURL, r, size, contentType); err != nil {
+		return err
 	}
 
-	// Create PUT request with file as body
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, presignedURL, file)
+	c.logger.Info("backup uploaded successfully",
+		"size_bytes", size,
+	)
+
+	return nil
+}
+
+// UploadImage PUTs an image (e.g. a webcam frame) to a presigned URL.
+func (c *Client) UploadImage(ctx context.Context, presignedURL string, data []byte, contentType string) error {
+	return c.uploadPresigned(ctx, presignedURL, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// uploadPresigned PUTs r to a presigned storage URL.
+func (c *Client) uploadPresigned(ctx context.Context, presignedURL string, r io.Reader, size int64, contentType string) error {
+	_, _, err := c.putPresigned(ctx, presignedURL, r, size, contentType)
+	return err
+}
+
+// putPresigned is uploadPresigned returning the response headers and
+// status (0 when no response arrived), for callers that retry or need the
+// ETag.
+func (c *Client) putPresigned(ctx context.Context, presignedURL string, r io.Reader, size int64, contentType string) (http.Header, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, presignedURL, r)
 	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
+		return nil, 0, fmt.Errorf("failed to create upload request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/gzip")
-	req.ContentLength = fileInfo.Size()
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = size
 
 	// Execute upload
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.uploadClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("upload request failed: %w", err)
+		return nil, 0, fmt.Errorf("upload request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -195,20 +664,43 @@ func (c *Client) UploadBackup(ctx context.Context, presignedURL, filePath string
 		if msg == "" {
 			msg = resp.Status
 		}
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, msg)
+		return nil, resp.StatusCode, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, msg)
 	}
 
-	c.logger.Info("backup uploaded successfully",
-		"size_bytes", fileInfo.Size(),
-		"status", resp.StatusCode,
-	)
+	return resp.Header, resp.StatusCode, nil
+}
 
-	return nil
+// DownloadFile GETs a file from a (typically presigned) URL. The caller must
+// close the returned body. size is the Content-Length, or -1 if unknown.
+// Like uploads it is bounded by Options.UploadTimeout, not the API timeout.
+func (c *Client) DownloadFile(ctx context.Context, fileURL string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create download request: %w", err)
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.uploadClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("download request failed: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		msg := strings.TrimSpace(string(respBody))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, 0, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	return resp.Body, resp.ContentLength, nil
 }
 
 // GetWebcamRequests fetches pending webcam snapshot requests for this connector
 func (c *Client) GetWebcamRequests(ctx context.Context, limit int) ([]WebcamRequest, error) {
-	path := fmt.Sprintf("/api/v1/connectors/%s/webcam_requests?limit=%d", url.PathEscape(c.connectorID), limit)
+	path := fmt.Sprintf("/api/v1/connectors/%s/webcam_requests?limit=%d", url.PathEscape(c.ConnectorID()), limit)
 	var out []WebcamRequest
 	if err := c.doJSON(ctx, http.MethodGet, path, c.authHeaders(), nil, &out); err != nil {
 		return nil, err
@@ -220,9 +712,9 @@ func (c *Client) GetWebcamRequests(ctx context.Context, limit int) ([]WebcamRequ
 // Returns nil on success
 func (c *Client) UploadWebcamSnapshot(ctx context.Context, requestID StringOrNumber, printerID int, imageData []byte, contentType string) error {
 	path := fmt.Sprintf("/api/v1/webcam_requests/%s/upload", url.PathEscape(requestID.String()))
-	
+
 	// Create request with image as body
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+path, bytes.NewReader(imageData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURLs[c.active.Load()]+path, bytes.NewReader(imageData))
 	if err != nil {
 		return fmt.Errorf("failed to create upload request: %w", err)
 	}
@@ -233,7 +725,7 @@ func (c *Client) UploadWebcamSnapshot(ctx context.Context, requestID StringOrNum
 	}
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("X-Printer-Id", fmt.Sprintf("%d", printerID))
-	
+
 	if c.userAgent != "" {
 		req.Header.Set("User-Agent", c.userAgent)
 	}
@@ -0,0 +1,43 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HTTPError is returned for a non-2xx cloud response (after any retries).
+type HTTPError struct {
+	StatusCode int
+	Body       string // trimmed response body, or the status text when empty
+	RequestID  string // X-Request-Id sent with the request
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("cloud http %d: %s (request_id %s)", e.StatusCode, e.Body, e.RequestID)
+}
+
+// StatusCode returns the HTTP status of err, or 0 when err isn't an
+// HTTPError.
+func StatusCode(err error) int {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode
+	}
+	return 0
+}
+
+// IsUnauthorized reports whether the cloud rejected the credentials (401).
+func IsUnauthorized(err error) bool { return StatusCode(err) == http.StatusUnauthorized }
+
+// IsForbidden reports a 403.
+func IsForbidden(err error) bool { return StatusCode(err) == http.StatusForbidden }
+
+// IsNotFound reports a 404, e.g. an endpoint an older cloud doesn't have.
+func IsNotFound(err error) bool { return StatusCode(err) == http.StatusNotFound }
+
+// IsRateLimited reports a 429 that persisted through the retries.
+func IsRateLimited(err error) bool { return StatusCode(err) == http.StatusTooManyRequests }
+
+// IsServerError reports a 5xx.
+func IsServerError(err error) bool { return StatusCode(err) >= 500 }
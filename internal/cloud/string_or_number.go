@@ -1,31 +1,83 @@
 package cloud
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
 
 // StringOrNumber accepts JSON values like 123 or "123" and stores them as a string.
+// Numbers are normalized to their integer form ("123.0" and "1.23e2" both become
+// "123") so the value can be used in URLs.
 type StringOrNumber string
 
+// maxNumberDigits bounds the integer an exponent may expand to; IDs are
+// nowhere near this long. maxNumberBits is the same bound in binary
+// (64·log2(10) rounded up), checked before the integer is built at all.
+const (
+	maxNumberDigits = 64
+	maxNumberBits   = 213
+)
+
 func (s *StringOrNumber) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
 	if len(b) == 0 || string(b) == "null" {
 		*s = ""
 		return nil
 	}
 
-	// If it's a JSON string: "123"
-	if b[0] == '"' {
+	switch b[0] {
+	case '"':
+		// If it's a JSON string: "123"
 		var str string
 		if err := json.Unmarshal(b, &str); err != nil {
 			return err
 		}
 		*s = StringOrNumber(str)
 		return nil
+	case 't', 'f':
+		return fmt.Errorf("string or number expected, got boolean %s", b)
+	case '{', '[':
+		return fmt.Errorf("string or number expected, got %s", b)
 	}
 
-	// Otherwise assume it's a number: 123
-	*s = StringOrNumber(string(b))
+	// Otherwise it's a number: 123, 123.0, 1.23e2
+	lit := string(b)
+	if bytes.IndexAny(b, ".eE") < 0 {
+		// Plain integers are kept verbatim, however large.
+		*s = StringOrNumber(lit)
+		return nil
+	}
+	f, _, err := big.ParseFloat(lit, 10, 256, big.ToNearestEven)
+	if err != nil {
+		return fmt.Errorf("invalid number %s: %w", lit, err)
+	}
+	// Expanding something like 1e100000000 would take forever.
+	if f.MantExp(nil) > maxNumberBits {
+		return fmt.Errorf("number %s is too large", lit)
+	}
+	if !f.IsInt() {
+		// Not an integer ID; keep what the server sent.
+		*s = StringOrNumber(lit)
+		return nil
+	}
+	i, _ := f.Int(nil)
+	str := i.String()
+	if len(str) > maxNumberDigits {
+		return fmt.Errorf("number %s is too large", lit)
+	}
+	*s = StringOrNumber(str)
 	return nil
 }
+
+// MarshalJSON always writes a JSON string, which UnmarshalJSON reads back
+// unchanged.
+func (s StringOrNumber) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(s))
+}
+
 // String returns the string value
 func (s StringOrNumber) String() string {
-return string(s)
+	return string(s)
 }
@@ -0,0 +1,68 @@
+package cloud
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStringOrNumberUnmarshal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: `"abc-123"`, want: "abc-123"},
+		{in: `"123"`, want: "123"},
+		{in: `null`, want: ""},
+		{in: `123`, want: "123"},
+		{in: `-7`, want: "-7"},
+		{in: `123.0`, want: "123"},
+		{in: `1.5`, want: "1.5"},
+		{in: `1.23e2`, want: "123"},
+		{in: `1E3`, want: "1000"},
+		{in: `1e-3`, want: "1e-3"},
+		{in: `1e-100000000`, want: "1e-100000000"},
+		// Plain integers beyond int64 are kept digit for digit.
+		{in: `123456789012345678901234567890`, want: "123456789012345678901234567890"},
+		{in: `1e63`, want: "1" + strings.Repeat("0", 63)},
+		{in: `1e64`, wantErr: "too large"},
+		{in: `1e100000000`, wantErr: "too large"},
+		{in: `-1e100000000`, wantErr: "too large"},
+		{in: `true`, wantErr: "boolean"},
+		{in: `{}`, wantErr: "string or number expected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var s StringOrNumber
+			err := json.Unmarshal([]byte(tt.in), &s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Unmarshal(%s) error = %v; want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.in, err)
+			}
+			if string(s) != tt.want {
+				t.Errorf("Unmarshal(%s) = %q; want %q", tt.in, s, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringOrNumberRoundTrip(t *testing.T) {
+	in := StringOrNumber("42")
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `"42"` {
+		t.Fatalf("Marshal = %s; want \"42\"", b)
+	}
+	var out StringOrNumber
+	if err := json.Unmarshal(b, &out); err != nil || out != in {
+		t.Errorf("round trip = %q, %v; want %q", out, err, in)
+	}
+}
@@ -0,0 +1,97 @@
+package cloud
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadTLSConfig builds the transport's TLS config from the mTLS and pinning
+// options.
+// It returns nil (Go defaults) when none are set.
+func loadTLSConfig(opts Options) (*tls.Config, error) {
+	if opts.ClientCertPath == "" && opts.ClientKeyPath == "" && opts.CACertPath == "" && opts.PinnedCertSHA256 == "" {
+		return nil, nil
+	}
+	if (opts.ClientCertPath == "") != (opts.ClientKeyPath == "") {
+		return nil, errors.New("client cert and key must be set together")
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.ClientCertPath != "" {
+		// LoadX509KeyPair also rejects a key that doesn't match the cert.
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertPath, opts.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CACertPath != "" {
+		pem, err := os.ReadFile(opts.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read ca cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACertPath)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.PinnedCertSHA256 != "" {
+		pin, err := parsePin(opts.PinnedCertSHA256)
+		if err != nil {
+			return nil, err
+		}
+		cfg.VerifyPeerCertificate = verifyPin(pin)
+	}
+
+	return cfg, nil
+}
+
+// ErrCertPinMismatch is returned (wrapped) when the cloud's leaf certificate
+// doesn't match PinnedCertSHA256, so it can be told apart from other TLS
+// failures.
+var ErrCertPinMismatch = errors.New("cloud certificate does not match pinned sha256")
+
+// parsePin decodes a SHA-256 pin given as hex (colons allowed) or base64.
+func parsePin(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "sha256/")
+	if b, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("pinned cert sha256 must be 32 bytes as hex or base64")
+}
+
+// verifyPin accepts the handshake only if the leaf certificate's SPKI hash
+// or whole-certificate hash equals pin. Normal chain verification still
+// runs first; the pin is an additional check.
+func verifyPin(pin []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("%w: no certificate presented", ErrCertPinMismatch)
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCertPinMismatch, err)
+		}
+		spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		cert := sha256.Sum256(leaf.Raw)
+		if subtle.ConstantTimeCompare(spki[:], pin) == 1 || subtle.ConstantTimeCompare(cert[:], pin) == 1 {
+			return nil
+		}
+		return fmt.Errorf("%w: server %q presented spki sha256 %s", ErrCertPinMismatch, leaf.Subject.CommonName, hex.EncodeToString(spki[:]))
+	}
+}
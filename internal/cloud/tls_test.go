@@ -0,0 +1,60 @@
+package cloud
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPresignedUploadsIgnoreCloudTLSOptions checks that the pin and CA
+// pool meant for the cloud aren't applied to presigned storage URLs: the
+// API call fails the pin, while the upload to the same (untrusted by the
+// system) server is judged by the system roots alone.
+func TestPresignedUploadsIgnoreCloudTLSOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(Options{
+		BaseURL:          srv.URL,
+		ConnectorID:      "1",
+		ConnectorSecret:  "secret",
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MaxAttempts:      1,
+		CACertPath:       caPath,
+		PinnedCertSHA256: strings.Repeat("ab", 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := c.GetCommands(ctx, "1", 1, ""); !errors.Is(err, ErrCertPinMismatch) {
+		t.Fatalf("GetCommands error = %v; want ErrCertPinMismatch", err)
+	}
+
+	err = c.UploadImage(ctx, srv.URL+"/bucket/frame.jpg", []byte("jpeg"), "image/jpeg")
+	if errors.Is(err, ErrCertPinMismatch) {
+		t.Fatalf("UploadImage applied the cloud pin to a presigned URL: %v", err)
+	}
+	var unknown x509.UnknownAuthorityError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("UploadImage error = %v; want verification against the system roots (unknown authority)", err)
+	}
+}
@@ -33,6 +33,8 @@ type RegisterResponse struct {
 		CommandsSeconds  int `json:"commands_seconds"`
 		SnapshotsSeconds int `json:"snapshots_seconds"`
 	} `json:"polling"`
+	LatestVersion string `json:"latest_version,omitempty"`
+	UpdateURL     string `json:"update_url,omitempty"`
 }
 
 type RegisteredPrinter struct {
@@ -42,15 +44,69 @@ type RegisteredPrinter struct {
 
 type HeartbeatRequest struct {
 	Status struct {
-		UptimeSeconds int64  `json:"uptime_seconds"`
-		Version       string `json:"version,omitempty"`
+		UptimeSeconds int64         `json:"uptime_seconds"`
+		Version       string        `json:"version,omitempty"`
+		System        *SystemStatus `json:"system,omitempty"`
 	} `json:"status"`
 	Printers []HeartbeatPrinter `json:"printers,omitempty"`
+
+	// Uploads lists backup uploads in progress.
+	Uploads []UploadProgress `json:"uploads,omitempty"`
+}
+
+type UploadProgress struct {
+	BackupID   string `json:"backup_id"`
+	BytesSent  int64  `json:"bytes_sent"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// SystemStatus is best-effort host health; fields that couldn't be read
+// (or are zero) are omitted.
+type SystemStatus struct {
+	Load1             float64 `json:"load_1,omitempty"`
+	Load5             float64 `json:"load_5,omitempty"`
+	Load15            float64 `json:"load_15,omitempty"`
+	MemTotalBytes     uint64  `json:"mem_total_bytes,omitempty"`
+	MemAvailableBytes uint64  `json:"mem_available_bytes,omitempty"`
+	DiskFreeBytes     uint64  `json:"disk_free_bytes,omitempty"`
+	DiskTotalBytes    uint64  `json:"disk_total_bytes,omitempty"`
+	CPUTemperatureC   float64 `json:"cpu_temperature_c,omitempty"`
+}
+
+// HeartbeatResponse carries optional update information from the cloud.
+type HeartbeatResponse struct {
+	LatestVersion string `json:"latest_version,omitempty"`
+	UpdateURL     string `json:"update_url,omitempty"`
 }
 
 type HeartbeatPrinter struct {
-	PrinterID int  `json:"printer_id"`
-	Reachable bool `json:"reachable"`
+	PrinterID int    `json:"printer_id"`
+	Name      string `json:"name,omitempty"`
+	Reachable bool   `json:"reachable"`
+
+	// Round trip of the reachability probe, and its error when it failed.
+	LatencyMillis int    `json:"latency_ms,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+
+	// MoonrakerVersion is reported once the printer's version was checked.
+	MoonrakerVersion string `json:"moonraker_version,omitempty"`
+
+	// Host describes the Moonraker host, once it has been read.
+	Host *PrinterHost `json:"host,omitempty"`
+
+	// UnreadAnnouncements counts Moonraker announcements (news, update
+	// notices) not yet dismissed; only with report_announcements.
+	UnreadAnnouncements int `json:"unread_announcements,omitempty"`
+}
+
+// PrinterHost is the hardware and software of a printer's Moonraker host,
+// for asset tracking.
+type PrinterHost struct {
+	CPUModel      string `json:"cpu_model,omitempty"`
+	CPUCount      int    `json:"cpu_count,omitempty"`
+	TotalMemoryKB int64  `json:"total_memory_kb,omitempty"`
+	Distribution  string `json:"distribution,omitempty"`
+	PythonVersion string `json:"python_version,omitempty"`
 }
 
 type Command struct {
@@ -60,12 +116,48 @@ type Command struct {
 	Params    map[string]any `json:"params"`
 }
 
+// CommandsPage is one page of pending commands. NextCursor is empty on the
+// last page.
+type CommandsPage struct {
+	Commands   []Command `json:"commands"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type CommandAckRequest struct {
+	Status string `json:"status"`
+}
+
 type CommandCompleteRequest struct {
 	Status       string         `json:"status"`
 	Result       map[string]any `json:"result,omitempty"`
 	ErrorMessage string         `json:"error_message,omitempty"`
 }
 
+// BatchCompletion is one entry of a BatchCompleteRequest; the completion's
+// fields are inlined next to the command ID.
+type BatchCompletion struct {
+	CommandID      StringOrNumber `json:"command_id"`
+	IdempotencyKey string         `json:"idempotency_key,omitempty"`
+	CommandCompleteRequest
+}
+
+type BatchCompleteRequest struct {
+	Completions []BatchCompletion `json:"completions"`
+}
+
+// Event types sent with PostEvent.
+const (
+	EventOnline  = "online"
+	EventOffline = "offline"
+)
+
+// Event records a printer state transition, e.g. going offline.
+type Event struct {
+	PrinterID int    `json:"printer_id"`
+	Type      string `json:"type"`
+	Timestamp string `json:"timestamp"`
+}
+
 type SnapshotsBatchRequest struct {
 	Snapshots []Snapshot `json:"snapshots"`
 }
@@ -74,8 +166,18 @@ type Snapshot struct {
 	PrinterID  int            `json:"printer_id"`
 	CapturedAt string         `json:"captured_at"`
 	Payload    map[string]any `json:"payload"`
+
+	// ClockSource says whose clock CapturedAt is from: ClockPrinter or
+	// ClockLocal.
+	ClockSource string `json:"clock_source,omitempty"`
 }
 
+// Snapshot clock sources.
+const (
+	ClockPrinter = "printer" // the Moonraker host's clock
+	ClockLocal   = "local"   // the connector's clock
+)
+
 type SnapshotsBatchResponse struct {
 	Inserted int `json:"inserted"`
 }
@@ -86,3 +188,54 @@ type WebcamRequest struct {
 	PrinterID int            `json:"printer_id"`
 	CreatedAt string         `json:"created_at,omitempty"`
 }
+
+// BackupMeta describes a locally created backup archive when requesting an upload URL
+type BackupMeta struct {
+	PrinterID int      `json:"printer_id,omitempty"`
+	Filename  string   `json:"filename"`
+	SizeBytes int64    `json:"size_bytes"`
+	SHA256    string   `json:"sha256"`
+	Includes  []string `json:"includes,omitempty"`
+	Encrypted bool     `json:"encrypted,omitempty"`
+
+	// IncrementalSince (RFC 3339) is set for incremental backups, which
+	// must be restored on top of the earlier backups they build on.
+	IncrementalSince string `json:"incremental_since,omitempty"`
+
+	// SupportsMultipart tells the cloud it may answer with a Multipart
+	// upload instead of a single upload_url.
+	SupportsMultipart bool `json:"supports_multipart,omitempty"`
+}
+
+// UploadTicket is the cloud's answer to a backup upload request
+type UploadTicket struct {
+	BackupID  StringOrNumber   `json:"backup_id"`
+	UploadURL string           `json:"upload_url"`
+	ExpiresAt string           `json:"expires_at,omitempty"`
+	Multipart *MultipartUpload `json:"multipart,omitempty"`
+}
+
+// MultipartUpload is a storage-side multipart upload (e.g. S3) with one
+// presigned URL per part. Every part but the last is PartSize bytes.
+type MultipartUpload struct {
+	UploadID string       `json:"upload_id"`
+	PartSize int64        `json:"part_size"`
+	Parts    []UploadPart `json:"parts"`
+}
+
+type UploadPart struct {
+	PartNumber int    `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// CompletedPart is an uploaded part as reported back to the cloud, which
+// needs the storage ETags to assemble the object.
+type CompletedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+type CompleteBackupUploadRequest struct {
+	UploadID string          `json:"upload_id"`
+	Parts    []CompletedPart `json:"parts"`
+}
@@ -0,0 +1,223 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"printer-connector/internal/util"
+)
+
+// UploadOptions tunes UploadBackup. The zero value does a single PUT.
+type UploadOptions struct {
+	// Multipart, when the cloud handed one out, uploads the file part by
+	// part; BackupID is then required to complete the upload.
+	Multipart *MultipartUpload
+	BackupID  StringOrNumber
+
+	// Progress, when set, is called with the bytes sent so far and the file
+	// size, at most once per progressInterval and once more on success.
+	Progress func(sent, total int64)
+
+	// ContentType of the archive (default "application/gzip"). Storage
+	// that signs the header rejects a PUT whose type differs.
+	ContentType string
+}
+
+// contentType is opts.ContentType or the default.
+func (opts UploadOptions) contentType() string {
+	if opts.ContentType != "" {
+		return opts.ContentType
+	}
+	return "application/gzip"
+}
+
+// progressInterval throttles UploadOptions.Progress; reads happen every
+// few KB, far more often than anyone needs to hear about.
+const progressInterval = time.Second
+
+// UploadBackup uploads a backup archive file to cloud storage (S3, GCS,
+// etc). With opts.Multipart each part goes to its own presigned URL and a
+// failed part is retried on its own, so a dropped connection near the end
+// doesn't restart the whole upload; otherwise the file is PUT to
+// presignedURL in one request, retried from the start on failure. Each
+// attempt is bounded by Options.UploadTimeout rather than the API timeout.
+func (c *Client) UploadBackup(ctx context.Context, presignedURL, filePath string, opts UploadOptions) error {
+	// Open backup file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	// Get file size for Content-Length
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat backup file: %w", err)
+	}
+	size := fileInfo.Size()
+	progress := &progressReporter{fn: opts.Progress, total: size}
+
+	if opts.Multipart != nil && len(opts.Multipart.Parts) > 0 {
+		return c.uploadMultipart(ctx, file, size, opts, progress)
+	}
+	if presignedURL == "" {
+		return errors.New("cloud: no upload url")
+	}
+	body := func() io.Reader { return progress.reader(io.NewSectionReader(file, 0, size), 0) }
+	if _, err := c.putRetrying(ctx, presignedURL, body, size, opts.contentType(), "backup"); err != nil {
+		return err
+	}
+	progress.done()
+	c.logger.Info("backup uploaded successfully",
+		"size_bytes", size,
+	)
+	return nil
+}
+
+func (c *Client) uploadMultipart(ctx context.Context, file *os.File, size int64, opts UploadOptions, progress *progressReporter) error {
+	mp, backupID := opts.Multipart, opts.BackupID
+	if backupID == "" {
+		return errors.New("cloud: multipart upload needs a backup id")
+	}
+	if mp.PartSize <= 0 {
+		return fmt.Errorf("cloud: invalid multipart part_size %d", mp.PartSize)
+	}
+	need := int((size + mp.PartSize - 1) / mp.PartSize)
+	if need == 0 {
+		// An empty file is still one (empty) part.
+		need = 1
+	}
+	if need > len(mp.Parts) {
+		return fmt.Errorf("cloud: multipart upload has %d part urls, %d bytes need %d", len(mp.Parts), size, need)
+	}
+
+	completed := make([]CompletedPart, 0, need)
+	for i, part := range mp.Parts[:need] {
+		off := int64(i) * mp.PartSize
+		n := min(mp.PartSize, size-off)
+		etag, err := c.uploadPart(ctx, file, part, off, n, opts.contentType(), progress)
+		if err != nil {
+			return err
+		}
+		completed = append(completed, CompletedPart{PartNumber: part.PartNumber, ETag: etag})
+	}
+
+	path := fmt.Sprintf("/api/v1/connectors/%s/backups/%s/complete", url.PathEscape(c.ConnectorID()), url.PathEscape(backupID.String()))
+	req := CompleteBackupUploadRequest{UploadID: mp.UploadID, Parts: completed}
+	if err := c.doJSON(ctx, http.MethodPost, path, c.authHeaders(), req, nil); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	progress.done()
+	c.logger.Info("backup uploaded successfully",
+		"size_bytes", size,
+		"parts", len(completed),
+	)
+	return nil
+}
+
+// uploadPart PUTs n bytes of file at off to the part's URL and returns
+// the ETag storage assigned to the part.
+func (c *Client) uploadPart(ctx context.Context, file *os.File, part UploadPart, off, n int64, contentType string, progress *progressReporter) (string, error) {
+	body := func() io.Reader { return progress.reader(io.NewSectionReader(file, off, n), off) }
+	header, err := c.putRetrying(ctx, part.URL, body, n, contentType, fmt.Sprintf("part %d", part.PartNumber))
+	if err != nil {
+		return "", err
+	}
+	etag := header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("upload of part %d returned no ETag", part.PartNumber)
+	}
+	return etag, nil
+}
+
+// putRetrying PUTs a body of size bytes to a presigned URL, retrying
+// connection errors, 429s and 5xx with backoff. body is called for every
+// attempt and must return a reader positioned at the start. what names the
+// upload in logs and errors.
+func (c *Client) putRetrying(ctx context.Context, presignedURL string, body func() io.Reader, size int64, contentType, what string) (http.Header, error) {
+	bo := util.NewBackoff(time.Second, 30*time.Second)
+	for attempt := 1; ; attempt++ {
+		header, status, err := c.putPresigned(ctx, presignedURL, body(), size, contentType)
+		if err == nil {
+			return header, nil
+		}
+
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || ctx.Err() != nil || attempt >= c.maxAttempts {
+			return nil, fmt.Errorf("%s: %w", what, err)
+		}
+		wait, _ := bo.Next()
+		c.logger.Warn("upload failed, retrying",
+			"upload", what,
+			"attempt", attempt,
+			"wait", wait,
+			"error", err,
+		)
+		if err := util.SleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// progressReporter calls fn with the bytes sent, throttled to
+// progressInterval. Retried parts count from their offset again, so a
+// retry shows as progress going back rather than past the total.
+type progressReporter struct {
+	fn    func(sent, total int64)
+	total int64
+
+	// The transport may still be reading an abandoned attempt's body when
+	// the retry starts.
+	mu   sync.Mutex
+	last time.Time
+}
+
+// reader counts reads from r as bytes sent after the first base bytes.
+func (p *progressReporter) reader(r io.Reader, base int64) io.Reader {
+	if p.fn == nil {
+		return r
+	}
+	return &countingReader{r: r, p: p, sent: base}
+}
+
+func (p *progressReporter) report(sent int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.fn(sent, p.total)
+	}
+}
+
+// done reports the upload as complete, bypassing the throttle.
+func (p *progressReporter) done() {
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fn(p.total, p.total)
+}
+
+type countingReader struct {
+	r    io.Reader
+	p    *progressReporter
+	sent int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if n > 0 {
+		c.sent += int64(n)
+		c.p.report(c.sent)
+	}
+	return n, err
+}
@@ -0,0 +1,71 @@
+package cloud
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUploadBackupContentType(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		got = append(got, r.Header.Get("Content-Type"))
+		mu.Unlock()
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer srv.Close()
+
+	c, err := New(Options{
+		BaseURL:         srv.URL,
+		ConnectorID:     "1",
+		ConnectorSecret: "secret",
+		Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "backup.zip")
+	if err := os.WriteFile(archive, []byte("PK archive"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := c.UploadBackup(ctx, srv.URL+"/put", archive, UploadOptions{}); err != nil {
+		t.Fatalf("UploadBackup default: %v", err)
+	}
+	if err := c.UploadBackup(ctx, srv.URL+"/put", archive, UploadOptions{ContentType: "application/zip"}); err != nil {
+		t.Fatalf("UploadBackup zip: %v", err)
+	}
+	if err := c.UploadBackup(ctx, "", archive, UploadOptions{
+		ContentType: "application/octet-stream",
+		BackupID:    "9",
+		Multipart: &MultipartUpload{
+			UploadID: "u",
+			PartSize: 1 << 20,
+			Parts:    []UploadPart{{PartNumber: 1, URL: srv.URL + "/part1"}},
+		},
+	}); err != nil {
+		t.Fatalf("UploadBackup multipart: %v", err)
+	}
+	if err := c.UploadBackupStream(ctx, srv.URL+"/put", strings.NewReader("stream"), -1, "application/zip"); err != nil {
+		t.Fatalf("UploadBackupStream: %v", err)
+	}
+
+	// The multipart completion POST is the cloud API, JSON.
+	want := []string{"application/gzip", "application/zip", "application/octet-stream", "application/json", "application/zip"}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Content-Types = %v; want %v", got, want)
+	}
+}
@@ -1,39 +1,279 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"printer-connector/internal/moonraker"
 )
 
 // DefaultCloudURL is the production cloud URL used when no override is provided
 const DefaultCloudURL = "https://www.spoolr.io"
 
+// MaxCommandsFetchLimit bounds commands_fetch_limit.
+const MaxCommandsFetchLimit = 100
+
+// DefaultMinMoonrakerVersion is the oldest Moonraker whose object shapes the
+// connector is known to handle.
+const DefaultMinMoonrakerVersion = "v0.8.0"
+
 type MoonrakerPrinter struct {
-	PrinterID int    `json:"printer_id"`
-	Name      string `json:"name"`
-	BaseURL   string `json:"base_url"`
-	UIPort    int    `json:"ui_port,omitempty"`
+	PrinterID int    `json:"printer_id" yaml:"printer_id"`
+	Name      string `json:"name" yaml:"name"`
+	BaseURL   string `json:"base_url" yaml:"base_url"`
+	UIPort    int    `json:"ui_port,omitempty" yaml:"ui_port,omitempty"`
+	APIKey    string `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+
+	// For an https base_url with a self-signed certificate (e.g. nginx in
+	// front of Moonraker): CACertPath is a PEM bundle to trust instead of the
+	// system roots, InsecureSkipVerify skips verification altogether.
+	CACertPath         string `json:"ca_cert_path,omitempty" yaml:"ca_cert_path,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+
+	// WebcamSnapshotURL is a single-frame JPEG URL for capture_image. When
+	// empty the usual /webcam endpoints on ui_port are tried.
+	WebcamSnapshotURL string `json:"webcam_snapshot_url,omitempty" yaml:"webcam_snapshot_url,omitempty"`
+
+	// Optional per-printer intervals; 0 falls back to the global setting.
+	SnapshotSeconds int `json:"snapshot_seconds,omitempty" yaml:"snapshot_seconds,omitempty"`
+	CommandSeconds  int `json:"command_seconds,omitempty" yaml:"command_seconds,omitempty"`
+
+	// AllowedActions, when non-empty, lists the only command actions this
+	// printer accepts; anything else fails with "action not permitted".
+	AllowedActions []string `json:"allowed_actions,omitempty" yaml:"allowed_actions,omitempty"`
+
+	// AllowedPins, when non-empty, lists the only output pins set_pin may
+	// change on this printer.
+	AllowedPins []string `json:"allowed_pins,omitempty" yaml:"allowed_pins,omitempty"`
+}
+
+// ActionAllowed reports whether the printer accepts action.
+func (p MoonrakerPrinter) ActionAllowed(action string) bool {
+	return len(p.AllowedActions) == 0 || slices.Contains(p.AllowedActions, action)
+}
+
+// PinAllowed reports whether set_pin may change pin, which may carry its
+// "output_pin " prefix.
+func (p MoonrakerPrinter) PinAllowed(pin string) bool {
+	return len(p.AllowedPins) == 0 || slices.Contains(p.AllowedPins, strings.TrimPrefix(pin, "output_pin "))
 }
 
 type Config struct {
-	CloudURL string `json:"cloud_url"`
+	// SchemaVersion is the config schema the file was written against (see
+	// migrate.go). Missing means a config from before versioning.
+	SchemaVersion int `json:"schema_version,omitempty" yaml:"schema_version,omitempty"`
+
+	CloudURL string `json:"cloud_url" yaml:"cloud_url"`
+	// CloudURLs lists active/standby endpoints, tried in order on connection
+	// failure. When set, cloud_url may be omitted (it defaults to the first).
+	CloudURLs []string `json:"cloud_urls,omitempty" yaml:"cloud_urls,omitempty"`
+
+	PairingToken    string `json:"pairing_token,omitempty" yaml:"pairing_token,omitempty"`
+	ConnectorID     string `json:"connector_id,omitempty" yaml:"connector_id,omitempty"`
+	ConnectorSecret string `json:"connector_secret,omitempty" yaml:"connector_secret,omitempty"`
+
+	// ConnectorSecretFile, when set, holds the connector secret instead of
+	// connector_secret (e.g. a file mounted by a secret store). Load reads
+	// it and SaveAtomic writes the secret there after pairing.
+	ConnectorSecretFile string `json:"connector_secret_file,omitempty" yaml:"connector_secret_file,omitempty"`
+
+	// RepairToken is a long-lived pairing token kept for re-pairing: when
+	// the cloud keeps rejecting the connector secret (revoked or rotated),
+	// the connector registers again with it to get new credentials.
+	RepairToken string `json:"repair_token,omitempty" yaml:"repair_token,omitempty"`
+
+	// SigningKey, when set, HMAC-signs cloud requests (X-Signature/X-Timestamp/X-Nonce).
+	SigningKey string `json:"signing_key,omitempty" yaml:"signing_key,omitempty"`
+
+	// BackupEncryptionKey, when set, encrypts backup archives with
+	// AES-256-GCM. 32 bytes, hex or base64 encoded.
+	BackupEncryptionKey string `json:"backup_encryption_key,omitempty" yaml:"backup_encryption_key,omitempty"`
+
+	// Optional mutual TLS for the cloud connection (PEM files).
+	ClientCertPath string `json:"client_cert_path,omitempty" yaml:"client_cert_path,omitempty"`
+	ClientKeyPath  string `json:"client_key_path,omitempty" yaml:"client_key_path,omitempty"`
+	CACertPath     string `json:"ca_cert_path,omitempty" yaml:"ca_cert_path,omitempty"`
+
+	// PinnedCertSHA256 pins the cloud's leaf certificate (SPKI or cert
+	// SHA-256, hex or base64).
+	PinnedCertSHA256 string `json:"pinned_cert_sha256,omitempty" yaml:"pinned_cert_sha256,omitempty"`
+
+	SiteName string `json:"site_name,omitempty" yaml:"site_name,omitempty"`
+
+	// UserAgentSuffix is appended to the cloud User-Agent
+	// ("printer-connector/<version> <suffix>") to tag deployments.
+	UserAgentSuffix string `json:"user_agent_suffix,omitempty" yaml:"user_agent_suffix,omitempty"`
+
+	PollCommandsSeconds  int `json:"poll_commands_seconds,omitempty" yaml:"poll_commands_seconds,omitempty"`
+	PushSnapshotsSeconds int `json:"push_snapshots_seconds,omitempty" yaml:"push_snapshots_seconds,omitempty"`
+	HeartbeatSeconds     int `json:"heartbeat_seconds,omitempty" yaml:"heartbeat_seconds,omitempty"`
+
+	// ReachabilityStableSeconds is how long a printer must stay offline (or
+	// back online) before an offline/online event is sent, so a flapping
+	// connection doesn't raise an alert per heartbeat (default 30).
+	ReachabilityStableSeconds int `json:"reachability_stable_seconds,omitempty" yaml:"reachability_stable_seconds,omitempty"`
+
+	// ReachabilityCacheMillis lets the heartbeat count a successful
+	// Moonraker query (e.g. a snapshot's) made within this window as the
+	// printer's reachability check instead of querying again (default 2000).
+	ReachabilityCacheMillis int `json:"reachability_cache_millis,omitempty" yaml:"reachability_cache_millis,omitempty"`
+
+	// ReportSystemMetrics adds host load, memory, disk and temperature to
+	// each heartbeat.
+	ReportSystemMetrics bool `json:"report_system_metrics,omitempty" yaml:"report_system_metrics,omitempty"`
+
+	// ReportAnnouncements adds each printer's count of unread Moonraker
+	// announcements to the heartbeat, to spot printers needing updates.
+	ReportAnnouncements bool `json:"report_announcements,omitempty" yaml:"report_announcements,omitempty"`
+
+	// ShutdownGraceSeconds bounds how long an in-flight command may keep
+	// running after SIGTERM so its completion can be reported (default 10).
+	ShutdownGraceSeconds int `json:"shutdown_grace_seconds,omitempty" yaml:"shutdown_grace_seconds,omitempty"`
+
+	// DeregisterOnShutdown removes the connector from the cloud on SIGTERM
+	// (within the shutdown grace period) instead of leaving it offline.
+	DeregisterOnShutdown bool `json:"deregister_on_shutdown,omitempty" yaml:"deregister_on_shutdown,omitempty"`
+
+	// CommandTimeoutSeconds bounds the execution of a single command (default 30).
+	CommandTimeoutSeconds int `json:"command_timeout_seconds,omitempty" yaml:"command_timeout_seconds,omitempty"`
+
+	// MaxConcurrentCommands bounds how many printers execute commands at
+	// once; commands for the same printer always run serially (default 4).
+	MaxConcurrentCommands int `json:"max_concurrent_commands,omitempty" yaml:"max_concurrent_commands,omitempty"`
+
+	// CommandsFetchLimit is the page size of a commands fetch (default 20,
+	// at most MaxCommandsFetchLimit). A poll reads up to 10 pages, so it
+	// also bounds how many commands one poll runs.
+	CommandsFetchLimit int `json:"commands_fetch_limit,omitempty" yaml:"commands_fetch_limit,omitempty"`
+
+	// AllowedGcodePrefixes, when non-empty, restricts run_gcode to scripts
+	// whose commands all start with one of these tokens (case-insensitive).
+	AllowedGcodePrefixes []string `json:"allowed_gcode_prefixes,omitempty" yaml:"allowed_gcode_prefixes,omitempty"`
+
+	// DryRun logs commands and reports them succeeded (result.dry_run)
+	// without sending anything to the printers.
+	DryRun bool `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+
+	// SnapshotObjects replaces the default printer objects in snapshots, in
+	// Moonraker's subscribe format: object name -> fields (empty = all).
+	SnapshotObjects map[string][]string `json:"snapshot_objects,omitempty" yaml:"snapshot_objects,omitempty"`
+
+	// FlattenSnapshots pushes the object map itself as the snapshot payload
+	// instead of Moonraker's {"result": {"status": ...}} envelope.
+	FlattenSnapshots bool `json:"flatten_snapshots,omitempty" yaml:"flatten_snapshots,omitempty"`
 
-	PairingToken    string `json:"pairing_token,omitempty"`
-	ConnectorID     string `json:"connector_id,omitempty"`
-	ConnectorSecret string `json:"connector_secret,omitempty"`
+	// SnapshotFieldAllowlist, when non-empty, limits snapshot payloads to
+	// these dot paths into the printer object map (e.g. "print_stats.state";
+	// a path through an array applies to each element). Everything else is
+	// dropped before the snapshot leaves the host.
+	SnapshotFieldAllowlist []string `json:"snapshot_field_allowlist,omitempty" yaml:"snapshot_field_allowlist,omitempty"`
 
-	SiteName string `json:"site_name,omitempty"`
+	// DedupeSnapshots skips pushing a printer's snapshot when its status is
+	// unchanged since the last push, except for a keepalive push every
+	// SnapshotKeepaliveSeconds (default 300).
+	DedupeSnapshots          bool `json:"dedupe_snapshots,omitempty" yaml:"dedupe_snapshots,omitempty"`
+	SnapshotKeepaliveSeconds int  `json:"snapshot_keepalive_seconds,omitempty" yaml:"snapshot_keepalive_seconds,omitempty"`
 
-	PollCommandsSeconds  int `json:"poll_commands_seconds,omitempty"`
-	PushSnapshotsSeconds int `json:"push_snapshots_seconds,omitempty"`
-	HeartbeatSeconds     int `json:"heartbeat_seconds,omitempty"`
+	// MaxSnapshotBufferBytes caps the on-disk buffer of snapshots that
+	// couldn't be pushed during a cloud outage (default 5MB).
+	MaxSnapshotBufferBytes int `json:"max_snapshot_buffer_bytes,omitempty" yaml:"max_snapshot_buffer_bytes,omitempty"`
 
-	StateDir  string             `json:"state_dir,omitempty"`
-	Moonraker []MoonrakerPrinter `json:"moonraker"`
+	// MaxSnapshotBatchSize caps how many snapshots go into one push; larger
+	// sets are split so a failed request only affects its batch (default 25).
+	MaxSnapshotBatchSize int `json:"max_snapshot_batch_size,omitempty" yaml:"max_snapshot_batch_size,omitempty"`
+
+	// StartupFlushBatchesPerSecond paces the push of snapshots left in the
+	// buffer from before a restart, so a long outage is caught up gradually
+	// rather than all at once (default 1).
+	StartupFlushBatchesPerSecond float64 `json:"startup_flush_batches_per_second,omitempty" yaml:"startup_flush_batches_per_second,omitempty"`
+
+	// MinMoonrakerVersion is the oldest Moonraker the connector is known to
+	// work with (default v0.8.0). Each printer's version is checked once on
+	// first contact; an older one is logged as a warning, or refused (no
+	// snapshots or commands) with RequireMoonrakerVersion.
+	MinMoonrakerVersion     string `json:"min_moonraker_version,omitempty" yaml:"min_moonraker_version,omitempty"`
+	RequireMoonrakerVersion bool   `json:"require_moonraker_version,omitempty" yaml:"require_moonraker_version,omitempty"`
+
+	// UseWebsocket pushes snapshots on change via Moonraker's websocket,
+	// falling back to polling while the socket is down.
+	UseWebsocket bool `json:"use_websocket,omitempty" yaml:"use_websocket,omitempty"`
+
+	// CompressRequests gzips large request bodies (e.g. snapshot batches).
+	CompressRequests bool `json:"compress_requests,omitempty" yaml:"compress_requests,omitempty"`
+	// CloudMaxAttempts bounds retries of transient cloud failures (default 3).
+	CloudMaxAttempts int `json:"cloud_max_attempts,omitempty" yaml:"cloud_max_attempts,omitempty"`
+	// CloudRateLimit caps cloud API requests per second (default 10), with
+	// bursts of up to CloudRateBurst (default 20).
+	CloudRateLimit float64 `json:"cloud_rate_limit,omitempty" yaml:"cloud_rate_limit,omitempty"`
+	CloudRateBurst int     `json:"cloud_rate_burst,omitempty" yaml:"cloud_rate_burst,omitempty"`
+	// After CircuitBreakerThreshold consecutive failed cloud calls (default
+	// 5) the connector stops calling the cloud for
+	// CircuitBreakerCooldownSeconds (default 30), then probes it once.
+	CircuitBreakerThreshold       int `json:"circuit_breaker_threshold,omitempty" yaml:"circuit_breaker_threshold,omitempty"`
+	CircuitBreakerCooldownSeconds int `json:"circuit_breaker_cooldown_seconds,omitempty" yaml:"circuit_breaker_cooldown_seconds,omitempty"`
+
+	// HTTP timeouts. Slow links (e.g. cellular) may need these raised.
+	CloudTimeoutSeconds        int `json:"cloud_timeout_seconds,omitempty" yaml:"cloud_timeout_seconds,omitempty"`                 // default 5
+	MoonrakerTimeoutSeconds    int `json:"moonraker_timeout_seconds,omitempty" yaml:"moonraker_timeout_seconds,omitempty"`         // default 5
+	DialTimeoutSeconds         int `json:"dial_timeout_seconds,omitempty" yaml:"dial_timeout_seconds,omitempty"`                   // default 2
+	TLSHandshakeTimeoutSeconds int `json:"tls_handshake_timeout_seconds,omitempty" yaml:"tls_handshake_timeout_seconds,omitempty"` // default 3
+	UploadTimeoutSeconds       int `json:"upload_timeout_seconds,omitempty" yaml:"upload_timeout_seconds,omitempty"`               // default 1800
+
+	// SlowRequestThresholdMillis: cloud API requests slower than this are
+	// logged as a warning with method, path, status and duration (default 2000).
+	SlowRequestThresholdMillis int `json:"slow_request_threshold_millis,omitempty" yaml:"slow_request_threshold_millis,omitempty"`
+
+	// MetricsAddr, when set, exposes Prometheus metrics on /metrics (e.g. ":9100").
+	MetricsAddr string `json:"metrics_addr,omitempty" yaml:"metrics_addr,omitempty"`
+	// HealthAddr, when set, serves /healthz and /readyz probes (e.g. ":8080").
+	HealthAddr string `json:"health_addr,omitempty" yaml:"health_addr,omitempty"`
+	// AdminAddr, when set, serves /status and a redacted /config. Without a
+	// host (e.g. ":8081") it binds to 127.0.0.1 only.
+	AdminAddr string `json:"admin_addr,omitempty" yaml:"admin_addr,omitempty"`
+
+	// AuditLogPath is the append-only JSON-lines record of every command
+	// executed (default <state_dir>/command_audit.jsonl). It is rotated at
+	// AuditLogMaxBytes (default 10MB), keeping AuditLogMaxFiles old files
+	// (default 5).
+	AuditLogPath     string `json:"audit_log_path,omitempty" yaml:"audit_log_path,omitempty"`
+	AuditLogMaxBytes int64  `json:"audit_log_max_bytes,omitempty" yaml:"audit_log_max_bytes,omitempty"`
+	AuditLogMaxFiles int    `json:"audit_log_max_files,omitempty" yaml:"audit_log_max_files,omitempty"`
+
+	StateDir  string             `json:"state_dir,omitempty" yaml:"state_dir,omitempty"`
+	Moonraker []MoonrakerPrinter `json:"moonraker" yaml:"moonraker"`
+
+	// Values taken from the environment, which SaveAtomic keeps out of the
+	// file (see applyEnvOverrides).
+	envOverrides  []envOverride
+	fileCloudURLs []string
+}
+
+// envOverride records a string field set from the environment, with the
+// value the file had for it.
+type envOverride struct {
+	field func(*Config) *string
+	env   string
+	file  string
+}
+
+// isYAML reports whether path should be read and written as YAML rather
+// than JSON. Unknown extensions default to JSON.
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
 }
 
 func Load(path string) (*Config, error) {
@@ -42,15 +282,52 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 	var c Config
-	if err := json.Unmarshal(b, &c); err != nil {
+	if isYAML(path) {
+		if err := yaml.Unmarshal(b, &c); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(b, &c); err != nil {
 		return nil, err
 	}
 
-	// Override cloud_url with environment variable if set
-	if envURL := os.Getenv("CLOUD_URL"); envURL != "" {
-		c.CloudURL = envURL
+	if err := Migrate(&c); err != nil {
+		return nil, err
+	}
+	if err := c.loadSecretFile(); err != nil {
+		return nil, err
 	}
+	applyEnvOverrides(&c)
 
+	c.applyDefaults()
+
+	return &c, nil
+}
+
+// loadSecretFile reads ConnectorSecret from ConnectorSecretFile. A missing
+// file is fine before pairing, which creates it.
+func (c *Config) loadSecretFile() error {
+	if c.ConnectorSecretFile == "" {
+		return nil
+	}
+	if c.ConnectorSecret != "" {
+		return errors.New("config must include only one of connector_secret or connector_secret_file")
+	}
+	b, err := os.ReadFile(c.ConnectorSecretFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("connector_secret_file: %w", err)
+	}
+	c.ConnectorSecret = strings.TrimSpace(string(b))
+	return nil
+}
+
+// applyDefaults fills in every unset field that has a default.
+func (c *Config) applyDefaults() {
+	if c.CloudURL == "" && len(c.CloudURLs) > 0 {
+		c.CloudURL = c.CloudURLs[0]
+	}
 	// Use default production URL if still empty
 	if c.CloudURL == "" {
 		c.CloudURL = DefaultCloudURL
@@ -65,9 +342,84 @@ func Load(path string) (*Config, error) {
 	if c.HeartbeatSeconds <= 0 {
 		c.HeartbeatSeconds = 10
 	}
+	if c.ReachabilityStableSeconds <= 0 {
+		c.ReachabilityStableSeconds = 30
+	}
+	if c.ReachabilityCacheMillis <= 0 {
+		c.ReachabilityCacheMillis = 2000
+	}
+	if c.ShutdownGraceSeconds <= 0 {
+		c.ShutdownGraceSeconds = 10
+	}
+	if c.CommandTimeoutSeconds <= 0 {
+		c.CommandTimeoutSeconds = 30
+	}
+	if c.MaxConcurrentCommands <= 0 {
+		c.MaxConcurrentCommands = 4
+	}
+	if c.CommandsFetchLimit == 0 {
+		c.CommandsFetchLimit = 20
+	}
+	if c.CloudMaxAttempts <= 0 {
+		c.CloudMaxAttempts = 3
+	}
+	if c.CloudRateLimit <= 0 {
+		c.CloudRateLimit = 10
+	}
+	if c.CloudRateBurst <= 0 {
+		c.CloudRateBurst = 20
+	}
+	if c.CircuitBreakerThreshold <= 0 {
+		c.CircuitBreakerThreshold = 5
+	}
+	if c.CircuitBreakerCooldownSeconds <= 0 {
+		c.CircuitBreakerCooldownSeconds = 30
+	}
+	if c.MaxSnapshotBufferBytes <= 0 {
+		c.MaxSnapshotBufferBytes = 5 << 20
+	}
+	if c.SnapshotKeepaliveSeconds <= 0 {
+		c.SnapshotKeepaliveSeconds = 300
+	}
+	if c.MaxSnapshotBatchSize <= 0 {
+		c.MaxSnapshotBatchSize = 25
+	}
+	if c.StartupFlushBatchesPerSecond <= 0 {
+		c.StartupFlushBatchesPerSecond = 1
+	}
+	if c.MinMoonrakerVersion == "" {
+		c.MinMoonrakerVersion = DefaultMinMoonrakerVersion
+	}
+	if c.CloudTimeoutSeconds == 0 {
+		c.CloudTimeoutSeconds = 5
+	}
+	if c.MoonrakerTimeoutSeconds == 0 {
+		c.MoonrakerTimeoutSeconds = 5
+	}
+	if c.DialTimeoutSeconds == 0 {
+		c.DialTimeoutSeconds = 2
+	}
+	if c.TLSHandshakeTimeoutSeconds == 0 {
+		c.TLSHandshakeTimeoutSeconds = 3
+	}
+	if c.UploadTimeoutSeconds == 0 {
+		c.UploadTimeoutSeconds = 1800
+	}
+	if c.SlowRequestThresholdMillis == 0 {
+		c.SlowRequestThresholdMillis = 2000
+	}
 	if c.StateDir == "" {
 		c.StateDir = "/var/lib/printer-connector"
 	}
+	if c.AuditLogPath == "" {
+		c.AuditLogPath = filepath.Join(c.StateDir, "command_audit.jsonl")
+	}
+	if c.AuditLogMaxBytes <= 0 {
+		c.AuditLogMaxBytes = 10 << 20
+	}
+	if c.AuditLogMaxFiles <= 0 {
+		c.AuditLogMaxFiles = 5
+	}
 
 	// Set default ui_port if not specified (vanilla Klipper usually uses port 80)
 	for i := range c.Moonraker {
@@ -75,8 +427,101 @@ func Load(path string) (*Config, error) {
 			c.Moonraker[i].UIPort = 80
 		}
 	}
+}
 
-	return &c, nil
+// Environment variables that override config file values. Env always wins
+// over the file, so secrets can be injected without writing them to disk.
+const (
+	EnvCloudURL        = "PRINTER_CONNECTOR_CLOUD_URL"
+	EnvConnectorID     = "PRINTER_CONNECTOR_ID"
+	EnvConnectorSecret = "PRINTER_CONNECTOR_SECRET"
+	EnvPairingToken    = "PRINTER_CONNECTOR_PAIRING_TOKEN"
+	EnvRepairToken     = "PRINTER_CONNECTOR_REPAIR_TOKEN"
+	EnvSigningKey      = "PRINTER_CONNECTOR_SIGNING_KEY"
+	EnvBackupKey       = "PRINTER_CONNECTOR_BACKUP_ENCRYPTION_KEY"
+)
+
+// applyEnvOverrides sets fields from the environment, remembering the file's
+// values so that SaveAtomic never writes an env-injected secret to disk.
+func applyEnvOverrides(c *Config) {
+	set := func(name string, field func(*Config) *string) {
+		v := os.Getenv(name)
+		if v == "" {
+			return
+		}
+		p := field(c)
+		c.envOverrides = append(c.envOverrides, envOverride{field: field, env: v, file: *p})
+		*p = v
+	}
+
+	// Legacy CLOUD_URL is still honored; the prefixed name takes precedence.
+	// An env URL replaces any cloud_urls list from the file.
+	cloudURL := func(c *Config) *string { return &c.CloudURL }
+	if os.Getenv("CLOUD_URL") != "" || os.Getenv(EnvCloudURL) != "" {
+		c.fileCloudURLs = c.CloudURLs
+		c.CloudURLs = nil
+	}
+	set("CLOUD_URL", cloudURL)
+	set(EnvCloudURL, cloudURL)
+	set(EnvConnectorID, func(c *Config) *string { return &c.ConnectorID })
+	set(EnvConnectorSecret, func(c *Config) *string { return &c.ConnectorSecret })
+	set(EnvPairingToken, func(c *Config) *string { return &c.PairingToken })
+	set(EnvRepairToken, func(c *Config) *string { return &c.RepairToken })
+	set(EnvSigningKey, func(c *Config) *string { return &c.SigningKey })
+	set(EnvBackupKey, func(c *Config) *string { return &c.BackupEncryptionKey })
+}
+
+// withoutEnvOverrides returns a copy of c with fields that still hold their
+// env value put back to the file's value. A field changed since (e.g. new
+// credentials from pairing) is kept.
+func (c *Config) withoutEnvOverrides() Config {
+	out := *c
+	// Undo in reverse so a variable overriding another restores the file's
+	// value, not the other variable's.
+	for i := len(c.envOverrides) - 1; i >= 0; i-- {
+		o := c.envOverrides[i]
+		if p := o.field(&out); *p == o.env {
+			*p = o.file
+			if p == &out.CloudURL {
+				out.CloudURLs = c.fileCloudURLs
+			}
+		}
+	}
+	return out
+}
+
+// BackupKey decodes BackupEncryptionKey. It returns nil when no key is set.
+func (c *Config) BackupKey() ([]byte, error) {
+	if c.BackupEncryptionKey == "" {
+		return nil, nil
+	}
+	k := strings.TrimSpace(c.BackupEncryptionKey)
+	if b, err := hex.DecodeString(k); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(k); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, errors.New("backup_encryption_key must be 32 bytes, hex or base64 encoded")
+}
+
+// Redacted returns a copy of c with secrets replaced, safe to display.
+func (c *Config) Redacted() Config {
+	const mask = "REDACTED"
+	r := *c
+	for _, s := range []*string{&r.PairingToken, &r.RepairToken, &r.ConnectorSecret, &r.SigningKey, &r.BackupEncryptionKey} {
+		if *s != "" {
+			*s = mask
+		}
+	}
+	r.Moonraker = make([]MoonrakerPrinter, len(c.Moonraker))
+	for i, m := range c.Moonraker {
+		if m.APIKey != "" {
+			m.APIKey = mask
+		}
+		r.Moonraker[i] = m
+	}
+	return r
 }
 
 func (c *Config) Validate() error {
@@ -86,6 +531,11 @@ func (c *Config) Validate() error {
 	if !strings.HasPrefix(c.CloudURL, "http://") && !strings.HasPrefix(c.CloudURL, "https://") {
 		return errors.New("cloud_url must start with http:// or https://")
 	}
+	for _, u := range c.CloudURLs {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("cloud_urls entry %q must start with http:// or https://", u)
+		}
+	}
 
 	hasPair := c.PairingToken != ""
 	hasCreds := c.ConnectorID != "" && c.ConnectorSecret != ""
@@ -96,6 +546,44 @@ func (c *Config) Validate() error {
 		return errors.New("config should not include pairing_token once connector_id + connector_secret exist")
 	}
 
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return errors.New("client_cert_path and client_key_path must be set together")
+	}
+	if _, err := c.BackupKey(); err != nil {
+		return err
+	}
+	if _, err := moonraker.ParseVersion(c.MinMoonrakerVersion); err != nil {
+		return fmt.Errorf("min_moonraker_version: %w", err)
+	}
+	for _, p := range c.SnapshotFieldAllowlist {
+		if slices.Contains(strings.Split(p, "."), "") {
+			return fmt.Errorf("snapshot_field_allowlist entry %q is not a valid dot path", p)
+		}
+	}
+
+	for name, v := range map[string]int{
+		"cloud_timeout_seconds":         c.CloudTimeoutSeconds,
+		"moonraker_timeout_seconds":     c.MoonrakerTimeoutSeconds,
+		"dial_timeout_seconds":          c.DialTimeoutSeconds,
+		"tls_handshake_timeout_seconds": c.TLSHandshakeTimeoutSeconds,
+		"upload_timeout_seconds":        c.UploadTimeoutSeconds,
+		"slow_request_threshold_millis": c.SlowRequestThresholdMillis,
+	} {
+		if v <= 0 {
+			return fmt.Errorf("%s must be > 0", name)
+		}
+	}
+
+	if c.CommandsFetchLimit < 1 || c.CommandsFetchLimit > MaxCommandsFetchLimit {
+		return fmt.Errorf("commands_fetch_limit must be between 1 and %d", MaxCommandsFetchLimit)
+	}
+
+	for name := range c.SnapshotObjects {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("snapshot_objects must not contain an empty object name")
+		}
+	}
+
 	if len(c.Moonraker) == 0 {
 		return errors.New("moonraker must include at least one printer entry")
 	}
@@ -115,6 +603,12 @@ func (c *Config) Validate() error {
 		if p.PrinterID > 0 {
 			seen[p.PrinterID] = true
 		}
+		if p.SnapshotSeconds < 0 {
+			return fmt.Errorf("moonraker snapshot_seconds must be >= 0 for printer_id %d", p.PrinterID)
+		}
+		if p.CommandSeconds < 0 {
+			return fmt.Errorf("moonraker command_seconds must be >= 0 for printer_id %d", p.PrinterID)
+		}
 		if p.BaseURL == "" {
 			return fmt.Errorf("moonraker base_url required for printer_id %d", p.PrinterID)
 		}
@@ -128,21 +622,49 @@ func (c *Config) Validate() error {
 	return nil
 }
 
-// SaveAtomic writes config JSON to disk atomically: write temp + rename.
+// SaveAtomic writes config to disk atomically: write temp + rename.
+// The format (JSON or YAML) follows the file extension, as in Load, and the
+// file is stamped with CurrentSchemaVersion. Values injected via the
+// environment are not written. With ConnectorSecretFile the secret is
+// written to that file instead of the config.
 // Uses 0600 permissions because config stores connector_secret.
 func SaveAtomic(path string, cfg *Config) error {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	cfg.SchemaVersion = CurrentSchemaVersion
+
+	out := cfg.withoutEnvOverrides()
+	if cfg.ConnectorSecretFile != "" {
+		if out.ConnectorSecret != "" {
+			if err := writeFileAtomic(cfg.ConnectorSecretFile, []byte(out.ConnectorSecret+"\n")); err != nil {
+				return fmt.Errorf("connector_secret_file: %w", err)
+			}
+		}
+		out.ConnectorSecret = ""
 	}
 
-	tmp := path + ".tmp"
-	b, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return err
+	var b []byte
+	var err error
+	if isYAML(path) {
+		b, err = yaml.Marshal(&out)
+		if err != nil {
+			return err
+		}
+	} else {
+		b, err = json.MarshalIndent(&out, "", "  ")
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
 	}
-	b = append(b, '\n')
+	return writeFileAtomic(path, b)
+}
 
+// writeFileAtomic writes b to path with 0600 permissions via a temp file
+// and rename, creating the directory if needed.
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
 	if err := os.WriteFile(tmp, b, 0600); err != nil {
 		return err
 	}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes content to a fresh config file named name.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv unsets every variable applyEnvOverrides reads.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CLOUD_URL", EnvCloudURL, EnvConnectorID, EnvConnectorSecret, EnvPairingToken, EnvRepairToken, EnvSigningKey, EnvBackupKey} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestSaveAtomicAfterPairing(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"cloud_url": "https://cloud.example.com", "pairing_token": "pair-123",
+			"moonraker": [{"name": "voron", "base_url": "http://127.0.0.1:7125"}]}`,
+		"config.yaml": "cloud_url: https://cloud.example.com\npairing_token: pair-123\n" +
+			"moonraker:\n  - name: voron\n    base_url: http://127.0.0.1:7125\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			path := writeConfig(t, name, content)
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			cfg.PairingToken = ""
+			cfg.ConnectorID = "42"
+			cfg.ConnectorSecret = "s3cret"
+			cfg.Moonraker[0].PrinterID = 7
+			if err := SaveAtomic(path, cfg); err != nil {
+				t.Fatalf("SaveAtomic: %v", err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), "pairing_token") || strings.Contains(string(b), "pair-123") {
+				t.Errorf("pairing token still in saved config:\n%s", b)
+			}
+			if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0600 {
+				t.Errorf("config mode = %v, %v; want 0600", st.Mode().Perm(), err)
+			}
+
+			got, err := Load(path)
+			if err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if got.ConnectorID != "42" || got.ConnectorSecret != "s3cret" || got.Moonraker[0].PrinterID != 7 {
+				t.Errorf("reloaded id/secret/printer_id = %q/%q/%d; want 42/s3cret/7", got.ConnectorID, got.ConnectorSecret, got.Moonraker[0].PrinterID)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("reloaded config invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveAtomicKeepsEnvValuesOut(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "config.json", `{"cloud_urls": ["https://a.example.com", "https://b.example.com"],
+		"connector_id": "42", "connector_secret": "file-secret",
+		"moonraker": [{"printer_id": 7, "base_url": "http://127.0.0.1:7125"}]}`)
+	t.Setenv(EnvCloudURL, "https://env.example.com")
+	t.Setenv(EnvConnectorSecret, "env-secret")
+	t.Setenv(EnvSigningKey, "env-signing-key")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.CloudURL != "https://env.example.com" || cfg.ConnectorSecret != "env-secret" {
+		t.Fatalf("env overrides not applied: %q, %q", cfg.CloudURL, cfg.ConnectorSecret)
+	}
+	cfg.ConnectorID = "43" // changed since load, so it is saved
+	if err := SaveAtomic(path, cfg); err != nil {
+		t.Fatalf("SaveAtomic: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"env.example.com", "env-secret", "env-signing-key"} {
+		if strings.Contains(string(b), v) {
+			t.Errorf("env value %q written to config:\n%s", v, b)
+		}
+	}
+
+	clearEnv(t)
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got.ConnectorSecret != "file-secret" || got.ConnectorID != "43" {
+		t.Errorf("reloaded id/secret = %q/%q; want 43/file-secret", got.ConnectorID, got.ConnectorSecret)
+	}
+	if len(got.CloudURLs) != 2 || got.CloudURL != "https://a.example.com" {
+		t.Errorf("reloaded cloud urls = %q %v; want the file's", got.CloudURL, got.CloudURLs)
+	}
+}
+
+func TestSaveAtomicSecretFile(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	path := writeConfig(t, "config.json", `{"cloud_url": "https://cloud.example.com", "pairing_token": "pair-123",
+		"connector_secret_file": "`+secretPath+`",
+		"moonraker": [{"base_url": "http://127.0.0.1:7125"}]}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.PairingToken = ""
+	cfg.ConnectorID = "42"
+	cfg.ConnectorSecret = "s3cret"
+	if err := SaveAtomic(path, cfg); err != nil {
+		t.Fatalf("SaveAtomic: %v", err)
+	}
+
+	secret, err := os.ReadFile(secretPath)
+	if err != nil || string(secret) != "s3cret\n" {
+		t.Errorf("secret file = %q, %v; want the secret", secret, err)
+	}
+	if st, err := os.Stat(secretPath); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("secret file mode = %v, %v; want 0600", st.Mode().Perm(), err)
+	}
+	b, _ := os.ReadFile(path)
+	if strings.Contains(string(b), "s3cret") {
+		t.Errorf("secret written to config despite connector_secret_file:\n%s", b)
+	}
+	got, err := Load(path)
+	if err != nil || got.ConnectorSecret != "s3cret" {
+		t.Errorf("reloaded secret = %q, %v; want s3cret", got.ConnectorSecret, err)
+	}
+}
+
+func TestSaveAtomicSecretFileSkipsEnvSecret(t *testing.T) {
+	clearEnv(t)
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	path := writeConfig(t, "config.json", `{"cloud_url": "https://cloud.example.com", "connector_id": "42",
+		"connector_secret_file": "`+secretPath+`",
+		"moonraker": [{"printer_id": 7, "base_url": "http://127.0.0.1:7125"}]}`)
+	t.Setenv(EnvConnectorSecret, "env-secret")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := SaveAtomic(path, cfg); err != nil {
+		t.Fatalf("SaveAtomic: %v", err)
+	}
+	if _, err := os.Stat(secretPath); !os.IsNotExist(err) {
+		b, _ := os.ReadFile(secretPath)
+		t.Errorf("env secret written to connector_secret_file: %q", b)
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import "fmt"
+
+// CurrentSchemaVersion is the config schema this binary writes. Bump it
+// together with a new entry in migrations when a field is renamed or
+// reshaped.
+const CurrentSchemaVersion = 1
+
+// migrations[v] upgrades a config from schema version v to v+1, before
+// defaults are applied.
+var migrations = []func(c *Config){
+	// 0: configs written before schema_version existed; every field they
+	// can contain is still read as-is.
+	func(c *Config) {},
+}
+
+// Migrate upgrades c in place to CurrentSchemaVersion. A config written by
+// a newer binary is rejected, since it may rely on fields this one would
+// silently ignore.
+func Migrate(c *Config) error {
+	if c.SchemaVersion < 0 {
+		return fmt.Errorf("invalid schema_version %d", c.SchemaVersion)
+	}
+	if c.SchemaVersion > CurrentSchemaVersion {
+		return fmt.Errorf("config schema_version %d is newer than supported (%d): downgrade not supported, upgrade printer-connector", c.SchemaVersion, CurrentSchemaVersion)
+	}
+	for v := c.SchemaVersion; v < CurrentSchemaVersion; v++ {
+		migrations[v](c)
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Schema returns a JSON Schema (draft 2020-12) for the config file, derived
+// from the Config struct tags; defaults are the values Load fills in. Only
+// fields listed in requiredFields are required. Unknown keys are allowed,
+// since Load ignores them too.
+func Schema() map[string]any {
+	defaults := Config{Moonraker: []MoonrakerPrinter{{}}}
+	defaults.applyDefaults()
+
+	s := objectSchema(reflect.TypeOf(Config{}), reflect.ValueOf(defaults))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "printer-connector config"
+	props := s["properties"].(map[string]any)
+	props["moonraker"].(map[string]any)["items"] = objectSchema(reflect.TypeOf(MoonrakerPrinter{}), reflect.ValueOf(defaults.Moonraker[0]))
+	props["moonraker"].(map[string]any)["minItems"] = 1
+	props["schema_version"].(map[string]any)["maximum"] = CurrentSchemaVersion
+	return s
+}
+
+// requiredFields lists, per struct, the keys Validate rejects a file
+// without. cloud_url and the credentials aren't among them: the environment
+// or connector_secret_file may supply those. printer_id may be left out
+// until pairing assigns one.
+var requiredFields = map[reflect.Type][]string{
+	reflect.TypeOf(Config{}):           {"moonraker"},
+	reflect.TypeOf(MoonrakerPrinter{}): {"base_url"},
+}
+
+// objectSchema describes struct type t. def holds the default of each field.
+func objectSchema(t reflect.Type, def reflect.Value) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		p := typeSchema(f.Type)
+		if v := def.Field(i); !v.IsZero() && v.Kind() != reflect.Slice && v.Kind() != reflect.Map {
+			p["default"] = v.Interface()
+		}
+		props[name] = p
+	}
+	s := map[string]any{
+		"type":       "object",
+		"properties": props,
+	}
+	if required := requiredFields[t]; len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// typeSchema describes a field of type t.
+func typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(t, reflect.Zero(t))
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// minimalConfig is the smallest file Load and Validate accept.
+func minimalConfig() map[string]any {
+	return map[string]any{
+		"cloud_url":     "https://cloud.example.com",
+		"pairing_token": "pair-123",
+		"moonraker": []any{
+			map[string]any{"base_url": "http://127.0.0.1:7125"},
+		},
+	}
+}
+
+func loadMap(t *testing.T, m map[string]any) (*Config, error) {
+	t.Helper()
+	clearEnv(t)
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestSchemaRequiredMatchesValidate(t *testing.T) {
+	cfg, err := loadMap(t, minimalConfig())
+	if err != nil {
+		t.Fatalf("Load(minimal): %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate(minimal): %v", err)
+	}
+
+	s := Schema()
+	for _, name := range s["required"].([]string) {
+		m := minimalConfig()
+		delete(m, name)
+		if cfg, err := loadMap(t, m); err == nil && cfg.Validate() == nil {
+			t.Errorf("schema requires %q but Validate accepts a config without it", name)
+		}
+	}
+
+	items := s["properties"].(map[string]any)["moonraker"].(map[string]any)["items"].(map[string]any)
+	for _, name := range items["required"].([]string) {
+		m := minimalConfig()
+		delete(m["moonraker"].([]any)[0].(map[string]any), name)
+		if cfg, err := loadMap(t, m); err == nil && cfg.Validate() == nil {
+			t.Errorf("schema requires moonraker %q but Validate accepts an entry without it", name)
+		}
+	}
+	for _, name := range []string{"name", "printer_id"} {
+		for _, r := range items["required"].([]string) {
+			if r == name {
+				t.Errorf("schema requires moonraker %q, which Validate doesn't", name)
+			}
+		}
+	}
+}
+
+func TestSchemaAllowsUnknownKeys(t *testing.T) {
+	m := minimalConfig()
+	m["_comment"] = "kept by hand"
+	cfg, err := loadMap(t, m)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	b, _ := json.Marshal(Schema())
+	if strings.Contains(string(b), `"additionalProperties":false`) {
+		t.Error("schema rejects unknown keys that Load ignores")
+	}
+}
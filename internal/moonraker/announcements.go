@@ -0,0 +1,59 @@
+package moonraker
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Announcement is one entry of Moonraker's announcement feeds (Moonraker
+// project news and update-manager notices).
+type Announcement struct {
+	EntryID     string    `json:"entry_id"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Priority    string    `json:"priority"` // "normal" or "high"
+	Date        time.Time `json:"date"`
+	Dismissed   bool      `json:"dismissed"`
+	Source      string    `json:"source"`
+	Feed        string    `json:"feed"`
+}
+
+// GetAnnouncements lists current announcements, dismissed ones included.
+func (c *Client) GetAnnouncements(ctx context.Context) ([]Announcement, error) {
+	var out struct {
+		Result struct {
+			Entries []struct {
+				EntryID     string  `json:"entry_id"`
+				URL         string  `json:"url"`
+				Title       string  `json:"title"`
+				Description string  `json:"description"`
+				Priority    string  `json:"priority"`
+				Date        float64 `json:"date"`
+				Dismissed   bool    `json:"dismissed"`
+				Source      string  `json:"source"`
+				Feed        string  `json:"feed"`
+			} `json:"entries"`
+		} `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/server/announcements/list?include_dismissed=true", nil, &out); err != nil {
+		return nil, err
+	}
+
+	items := make([]Announcement, 0, len(out.Result.Entries))
+	for _, e := range out.Result.Entries {
+		items = append(items, Announcement{
+			EntryID:     e.EntryID,
+			URL:         e.URL,
+			Title:       e.Title,
+			Description: e.Description,
+			Priority:    e.Priority,
+			Date:        unixTime(e.Date),
+			Dismissed:   e.Dismissed,
+			Source:      e.Source,
+			Feed:        e.Feed,
+		})
+	}
+	return items, nil
+}
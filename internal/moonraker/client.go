@@ -3,29 +3,78 @@ package moonraker
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"mime/multipart"
-	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 )
 
 type Client struct {
-	baseURL    string
-	uiBaseURL  string
-	httpClient *http.Client
+	baseURL     string
+	uiBaseURL   string
+	apiKey      string
+	httpClient  *http.Client
+	dialTimeout time.Duration
+
+	// uploadClient has no total timeout, since a large G-code upload can
+	// outlast it; uploads are bounded by their context instead.
+	uploadClient *http.Client
+
+	// tlsConfig is nil unless Options set CACertPath or InsecureSkipVerify.
+	tlsConfig *tls.Config
+}
+
+// Options configures a Moonraker client.
+type Options struct {
+	BaseURL string
+	UIPort  int
+	APIKey  string // optional; sent as X-Api-Key when Moonraker enforces logins
+
+	// Timeouts; zero values keep the defaults (5s total, 2s dial, 3s TLS).
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+
+	// For an https base URL behind a self-signed certificate: CACertPath is
+	// a PEM bundle trusted instead of the system roots; InsecureSkipVerify
+	// turns verification off entirely.
+	CACertPath         string
+	InsecureSkipVerify bool
 }
 
 func New(baseURL string, uiPort int) *Client {
-	transport := &http.Transport{
-		DialContext:           (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
-		ResponseHeaderTimeout: 5 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
+	// Without TLS options NewWithOptions can't fail.
+	c, _ := NewWithOptions(Options{BaseURL: baseURL, UIPort: uiPort})
+	return c
+}
+
+// NewWithOptions returns a client for opts. It fails only when CACertPath
+// can't be loaded.
+func NewWithOptions(opts Options) (*Client, error) {
+	tlsConfig, err := loadTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	timeout := durationOr(opts.Timeout, 5*time.Second)
+	dialTimeout := durationOr(opts.DialTimeout, 2*time.Second)
+	transport := sharedTransport(opts.BaseURL, dialTimeout, durationOr(opts.TLSHandshakeTimeout, 3*time.Second), timeout, opts, tlsConfig)
+	httpClient := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
 	}
+	uploadClient := &http.Client{Transport: transport}
+
+	baseURL := opts.BaseURL
+	uiPort := opts.UIPort
 
 	// Default to port 80 if not specified (vanilla Klipper default)
 	if uiPort == 0 {
@@ -38,39 +87,107 @@ func New(baseURL string, uiPort int) *Client {
 	if err != nil {
 		// Fallback: just use baseURL for both
 		return &Client{
-			baseURL:   strings.TrimRight(baseURL, "/"),
-			uiBaseURL: strings.TrimRight(baseURL, "/"),
-			httpClient: &http.Client{
-				Timeout:   5 * time.Second,
-				Transport: transport,
-			},
-		}
+			baseURL:      strings.TrimRight(baseURL, "/"),
+			uiBaseURL:    strings.TrimRight(baseURL, "/"),
+			apiKey:       opts.APIKey,
+			httpClient:   httpClient,
+			dialTimeout:  dialTimeout,
+			uploadClient: uploadClient,
+			tlsConfig:    tlsConfig,
+		}, nil
 	}
 
 	// Build UI URL with the specified UI port
 	uiBaseURL := fmt.Sprintf("%s://%s:%d", parsedURL.Scheme, parsedURL.Hostname(), uiPort)
 
 	return &Client{
-		baseURL:   strings.TrimRight(baseURL, "/"),
-		uiBaseURL: strings.TrimRight(uiBaseURL, "/"),
-		httpClient: &http.Client{
-			Timeout:   5 * time.Second,
-			Transport: transport,
-		},
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		uiBaseURL:    strings.TrimRight(uiBaseURL, "/"),
+		apiKey:       opts.APIKey,
+		httpClient:   httpClient,
+		dialTimeout:  dialTimeout,
+		uploadClient: uploadClient,
+		tlsConfig:    tlsConfig,
+	}, nil
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// setAuth attaches the Moonraker API key to req, if one is configured.
+func (c *Client) setAuth(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("X-Api-Key", c.apiKey)
 	}
 }
 
+// OneshotToken asks Moonraker for a token that authenticates a single
+// request (as ?token=) and expires after a few seconds, so URLs handed out
+// or logged never carry the API key.
+func (c *Client) OneshotToken(ctx context.Context) (string, error) {
+	var resp struct {
+		Result string `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/access/oneshot_token", nil, &resp); err != nil {
+		return "", fmt.Errorf("oneshot token: %w", err)
+	}
+	if resp.Result == "" {
+		return "", fmt.Errorf("oneshot token: empty result")
+	}
+	return resp.Result, nil
+}
+
+// withOneshotToken adds a one-shot token to rawURL when an API key is
+// configured and rawURL points at the Moonraker host. Other hosts (e.g. a
+// separate camera) never see a token, and without an API key Moonraker
+// needs none.
+func (c *Client) withOneshotToken(ctx context.Context, rawURL string) (string, error) {
+	if c.apiKey == "" {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil || !strings.EqualFold(u.Hostname(), base.Hostname()) {
+		return rawURL, nil
+	}
+	token, err := c.OneshotToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// gcodeFileURL is the files endpoint for clean, a ValidateGcodePath result.
+func (c *Client) gcodeFileURL(clean string) string {
+	segs := strings.Split(clean, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return c.baseURL + "/server/files/gcodes/" + strings.Join(segs, "/")
+}
+
 func (c *Client) QueryObjects(ctx context.Context) (map[string]any, error) {
-	req := map[string]any{
-		"objects": map[string]any{
-			"print_stats":    nil,
-			"virtual_sdcard": nil,
-			"extruder":       nil,
-			"heater_bed":     nil,
-			"toolhead":       nil,
-			"pause_resume":   nil,
-		},
+	objects := map[string]any{}
+	for name, fields := range DefaultSnapshotObjects() {
+		objects[name] = fields
 	}
+	return c.QueryObjectsCustom(ctx, objects)
+}
+
+// QueryObjectsCustom queries the given printer objects. Values are the
+// field lists to return, or nil for every field of the object.
+func (c *Client) QueryObjectsCustom(ctx context.Context, objects map[string]any) (map[string]any, error) {
+	req := map[string]any{"objects": objects}
 
 	var out map[string]any
 	if err := c.postJSON(ctx, "/printer/objects/query", req, &out); err != nil {
@@ -79,6 +196,81 @@ func (c *Client) QueryObjects(ctx context.Context) (map[string]any, error) {
 	return out, nil
 }
 
+// QueryObjectsAt is QueryObjectsCustom that also returns the printer host's
+// wall clock when it answered, from the HTTP Date header (zero if absent).
+// The response's eventtime is Klipper's monotonic clock, seconds since the
+// host booted, so it can't serve as a timestamp itself.
+func (c *Client) QueryObjectsAt(ctx context.Context, objects map[string]any) (map[string]any, time.Time, error) {
+	var out map[string]any
+	header, err := c.do(ctx, http.MethodPost, "/printer/objects/query", map[string]any{"objects": objects}, &out)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	at, _ := http.ParseTime(header.Get("Date"))
+	return out, at, nil
+}
+
+// ListObjects returns the names of every printer object Klipper exposes.
+func (c *Client) ListObjects(ctx context.Context) ([]string, error) {
+	var out struct {
+		Result struct {
+			Objects []string `json:"objects"`
+		} `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/printer/objects/list", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Result.Objects, nil
+}
+
+// IsFilamentSensor reports whether name is a filament_switch_sensor or
+// filament_motion_sensor object (e.g. "filament_switch_sensor runout").
+func IsFilamentSensor(name string) bool {
+	return strings.HasPrefix(name, "filament_switch_sensor ") || strings.HasPrefix(name, "filament_motion_sensor ")
+}
+
+// ExtractStatus returns the printer object map from a query response,
+// dropping the envelope. It understands the HTTP shape {"result": {"status":
+// ...}}, a bare {"status": ...} result, and the websocket notification shape
+// {"method": "notify_status_update", "params": [status, eventtime]}.
+func ExtractStatus(resp map[string]any) (map[string]any, bool) {
+	if result, ok := resp["result"].(map[string]any); ok {
+		resp = result
+	}
+	if status, ok := resp["status"].(map[string]any); ok {
+		return status, true
+	}
+	if params, ok := resp["params"].([]any); ok && len(params) > 0 {
+		if status, ok := params[0].(map[string]any); ok {
+			return status, true
+		}
+	}
+	return nil, false
+}
+
+// ReplaceStatus returns a copy of resp, in any shape ExtractStatus
+// understands, with the printer object map replaced by status. resp itself
+// is not modified. Unknown shapes are returned unchanged.
+func ReplaceStatus(resp, status map[string]any) map[string]any {
+	out := maps.Clone(resp)
+	if result, ok := resp["result"].(map[string]any); ok {
+		out["result"] = ReplaceStatus(result, status)
+		return out
+	}
+	if _, ok := resp["status"].(map[string]any); ok {
+		out["status"] = status
+		return out
+	}
+	if params, ok := resp["params"].([]any); ok && len(params) > 0 {
+		if _, ok := params[0].(map[string]any); ok {
+			params = slices.Clone(params)
+			params[0] = status
+			out["params"] = params
+		}
+	}
+	return out
+}
+
 func (c *Client) Pause(ctx context.Context) error {
 	return c.postJSON(ctx, "/printer/print/pause", map[string]any{}, nil)
 }
@@ -91,24 +283,209 @@ func (c *Client) Cancel(ctx context.Context) error {
 	return c.postJSON(ctx, "/printer/print/cancel", map[string]any{}, nil)
 }
 
+// EmergencyStop immediately halts the printer (equivalent to M112).
+// Klipper enters a shutdown state and requires a firmware restart afterwards.
+func (c *Client) EmergencyStop(ctx context.Context) error {
+	return c.postJSON(ctx, "/printer/emergency_stop", map[string]any{}, nil)
+}
+
+// FirmwareRestart restarts the MCU firmware and Klippy (FIRMWARE_RESTART),
+// the way out of an error or emergency stop.
+func (c *Client) FirmwareRestart(ctx context.Context) error {
+	return c.postJSON(ctx, "/printer/firmware_restart", map[string]any{}, nil)
+}
+
+// HostRestart restarts Klippy (RESTART), reloading printer.cfg without
+// resetting the MCU.
+func (c *Client) HostRestart(ctx context.Context) error {
+	return c.postJSON(ctx, "/printer/restart", map[string]any{}, nil)
+}
+
+// PrinterInfo is the part of /printer/info the connector uses. State is
+// Klippy's: "ready", "startup", "shutdown" or "error".
+type PrinterInfo struct {
+	State        string `json:"state"`
+	StateMessage string `json:"state_message"`
+}
+
+func (c *Client) PrinterInfo(ctx context.Context) (*PrinterInfo, error) {
+	var out struct {
+		Result PrinterInfo `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/printer/info", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Result, nil
+}
+
 // Home executes the G28 homing command. If axes is empty, homes X Y Z.
-// Valid axes are "X", "Y", "Z". Example: Home(ctx, "X", "Y") homes X and Y only.
+// Valid axes are "X", "Y", "Z"; anything else is an error rather than being
+// dropped, since a bare G28 would home every axis. Example: Home(ctx, "X",
+// "Y") homes X and Y only.
 func (c *Client) Home(ctx context.Context, axes ...string) error {
-	gcode := "G28"
 	if len(axes) == 0 {
 		// Default: home all axes explicitly
-		gcode = "G28 X Y Z"
-	} else {
-		// Home specific axes: G28 X Y
-		for _, axis := range axes {
-			axis = strings.ToUpper(strings.TrimSpace(axis))
-			if axis == "X" || axis == "Y" || axis == "Z" {
-				gcode += " " + axis
-			}
+		return c.RunGcode(ctx, "G28 X Y Z")
+	}
+	// Home specific axes: G28 X Y
+	gcode := "G28"
+	for _, axis := range axes {
+		switch a := strings.ToUpper(strings.TrimSpace(axis)); a {
+		case "X", "Y", "Z":
+			gcode += " " + a
+		default:
+			return fmt.Errorf("invalid axis %q (valid: X, Y, Z)", axis)
+		}
+	}
+	return c.RunGcode(ctx, gcode)
+}
+
+// HomeAxes homes the axes named by letters in axes (e.g. "XY"); an empty
+// string homes all axes. Letters other than X, Y and Z are an error, as is
+// a non-empty string naming no axis at all.
+func (c *Client) HomeAxes(ctx context.Context, axes string) error {
+	var list []string
+	for _, r := range axes {
+		if r == ' ' || r == ',' {
+			continue
+		}
+		list = append(list, string(r))
+	}
+	if len(list) == 0 && axes != "" {
+		return fmt.Errorf("invalid axes %q (valid: X, Y, Z)", axes)
+	}
+	return c.Home(ctx, list...)
+}
+
+// MoveToolhead moves the toolhead to the given absolute coordinates. Nil
+// coordinates are left unchanged; feedrate is in mm/min (0 = printer default).
+func (c *Client) MoveToolhead(ctx context.Context, x, y, z *float64, feedrate float64) error {
+	move := "G1"
+	for _, axis := range []struct {
+		name string
+		v    *float64
+	}{{"X", x}, {"Y", y}, {"Z", z}} {
+		if axis.v != nil {
+			move += fmt.Sprintf(" %s%g", axis.name, *axis.v)
+		}
+	}
+	if move == "G1" {
+		return fmt.Errorf("move requires at least one of x, y, z")
+	}
+	if feedrate > 0 {
+		move += fmt.Sprintf(" F%g", feedrate)
+	}
+	// Force absolute positioning so the coordinates mean what they say
+	return c.RunGcode(ctx, "G90\n"+move)
+}
+
+// PrintState returns print_stats.state ("standby", "printing", "paused",
+// "complete", "cancelled" or "error").
+func (c *Client) PrintState(ctx context.Context) (string, error) {
+	req := map[string]any{"objects": map[string]any{"print_stats": []string{"state"}}}
+	var out struct {
+		Result struct {
+			Status struct {
+				PrintStats struct {
+					State string `json:"state"`
+				} `json:"print_stats"`
+			} `json:"status"`
+		} `json:"result"`
+	}
+	if err := c.postJSON(ctx, "/printer/objects/query", req, &out); err != nil {
+		return "", err
+	}
+	return out.Result.Status.PrintStats.State, nil
+}
+
+// Temperature limits accepted by SetTemperature, in °C.
+const (
+	MinTargetTemp = 0
+	MaxTargetTemp = 350
+)
+
+// SetTemperature sets the target temperature of heater (e.g. "extruder",
+// "heater_bed", or a heater_generic name) via SET_HEATER_TEMPERATURE.
+// The heater must be one Klipper reports as available.
+func (c *Client) SetTemperature(ctx context.Context, heater string, target float64) error {
+	if target < MinTargetTemp || target > MaxTargetTemp {
+		return fmt.Errorf("target temperature %.1f out of range (%d-%d)", target, MinTargetTemp, MaxTargetTemp)
+	}
+
+	heaters, err := c.AvailableHeaters(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list heaters: %w", err)
+	}
+	name := ""
+	for _, h := range heaters {
+		// heater_generic entries are reported as "heater_generic <name>" but
+		// addressed by <name> alone in SET_HEATER_TEMPERATURE.
+		short := h
+		if i := strings.LastIndex(h, " "); i >= 0 {
+			short = h[i+1:]
+		}
+		if heater == h || heater == short {
+			name = short
+			break
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("unknown heater %q (available: %s)", heater, strings.Join(heaters, ", "))
+	}
+
+	return c.RunGcode(ctx, fmt.Sprintf("SET_HEATER_TEMPERATURE HEATER=%s TARGET=%g", name, target))
+}
+
+// AvailableHeaters returns the heaters Klipper has configured.
+func (c *Client) AvailableHeaters(ctx context.Context) ([]string, error) {
+	req := map[string]any{"objects": map[string]any{"heaters": nil}}
+	var out struct {
+		Result struct {
+			Status struct {
+				Heaters struct {
+					AvailableHeaters []string `json:"available_heaters"`
+				} `json:"heaters"`
+			} `json:"status"`
+		} `json:"result"`
+	}
+	if err := c.postJSON(ctx, "/printer/objects/query", req, &out); err != nil {
+		return nil, err
+	}
+	return out.Result.Status.Heaters.AvailableHeaters, nil
+}
+
+// SetOutputPin sets an [output_pin] to value via SET_PIN. value is in 0-1
+// (a PWM duty cycle, or off/on for digital pins). pin may be given with or
+// without its "output_pin " prefix.
+func (c *Client) SetOutputPin(ctx context.Context, pin string, value float64) error {
+	if value < 0 || value > 1 {
+		return fmt.Errorf("pin value %g out of range (0-1)", value)
+	}
+	name := strings.TrimPrefix(pin, "output_pin ")
+	if !validPinName(name) {
+		return fmt.Errorf("invalid pin name %q", pin)
+	}
+	return c.RunGcode(ctx, fmt.Sprintf("SET_PIN PIN=%s VALUE=%g", name, value))
+}
+
+// validPinName reports whether name is a plain Klipper config section name,
+// so it can't smuggle extra parameters or commands into the gcode.
+func validPinName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
 		}
 	}
-	req := map[string]any{"script": gcode}
-	return c.postJSON(ctx, "/printer/gcode/script", req, nil)
+	return true
+}
+
+// RunGcode executes a gcode script (one or more newline-separated commands).
+// Moonraker responds once Klipper has finished running the script.
+func (c *Client) RunGcode(ctx context.Context, script string) error {
+	return c.postJSON(ctx, "/printer/gcode/script?script="+url.QueryEscape(script), map[string]any{}, nil)
 }
 
 func (c *Client) StartPrint(ctx context.Context, filename string) error {
@@ -119,6 +496,7 @@ func (c *Client) StartPrint(ctx context.Context, filename string) error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	c.setAuth(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
@@ -136,21 +514,39 @@ func (c *Client) StartPrint(ctx context.Context, filename string) error {
 }
 
 func (c *Client) postJSON(ctx context.Context, path string, body any, out any) error {
+	return c.doJSON(ctx, http.MethodPost, path, body, out)
+}
+
+// doJSON sends body (omitted when nil) as JSON and decodes the response into out.
+func (c *Client) doJSON(ctx context.Context, method, path string, body any, out any) error {
+	_, err := c.do(ctx, method, path, body, out)
+	return err
+}
+
+// do is doJSON that also returns the response headers.
+func (c *Client) do(ctx context.Context, method, path string, body any, out any) (http.Header, error) {
 	full := c.baseURL + path
-	b, err := json.Marshal(body)
-	if err != nil {
-		return err
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, full, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, method, full, reqBody)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	c.setAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	respB, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
@@ -160,20 +556,19 @@ func (c *Client) postJSON(ctx context.Context, path string, body any, out any) e
 		if msg == "" {
 			msg = resp.Status
 		}
-		return fmt.Errorf("moonraker http %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("moonraker http %d: %s", resp.StatusCode, msg)
 	}
 
 	if out == nil {
-		return nil
+		return resp.Header, nil
 	}
 	if len(respB) == 0 {
 		if mptr, ok := out.(*map[string]any); ok {
 			*mptr = map[string]any{}
-			return nil
 		}
-		return nil
+		return resp.Header, nil
 	}
-	return json.Unmarshal(respB, out)
+	return resp.Header, json.Unmarshal(respB, out)
 }
 
 // UploadFile uploads a file to Moonraker
@@ -209,6 +604,7 @@ func (c *Client) UploadFile(ctx context.Context, filename string, content []byte
 		return err
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	c.setAuth(req)
 
 	// Execute request
 	resp, err := c.httpClient.Do(req)
@@ -230,8 +626,87 @@ func (c *Client) UploadFile(ctx context.Context, filename string, content []byte
 	return nil
 }
 
-// GetHistory fetches print job history from Moonraker
-func (c *Client) GetHistory(ctx context.Context, limit int) (map[string]any, error) {
+// MaxGcodeBytes caps uploads made with UploadGcode.
+const MaxGcodeBytes = 1 << 30 // 1GB
+
+// ValidateGcodeFilename rejects names Moonraker could place outside the
+// gcodes root, and anything without a .gcode/.gco extension.
+func ValidateGcodeFilename(filename string) error {
+	if filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+	if strings.ContainsAny(filename, "/\\") || filename == "." || filename == ".." {
+		return fmt.Errorf("invalid filename %q", filename)
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".gcode", ".gco":
+		return nil
+	}
+	return fmt.Errorf("filename %q must have a .gcode or .gco extension", filename)
+}
+
+// UploadGcode streams r to Moonraker's gcodes root as filename. The upload
+// fails if r yields more than MaxGcodeBytes. It isn't subject to the client
+// timeout; ctx bounds it.
+func (c *Client) UploadGcode(ctx context.Context, filename string, r io.Reader) error {
+	if err := ValidateGcodeFilename(filename); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	// Write the form in the background so the file is never buffered whole.
+	go func() {
+		err := func() error {
+			if err := writer.WriteField("root", "gcodes"); err != nil {
+				return err
+			}
+			part, err := writer.CreateFormFile("file", filename)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(part, io.LimitReader(r, MaxGcodeBytes+1))
+			if err != nil {
+				return err
+			}
+			if n > MaxGcodeBytes {
+				return fmt.Errorf("file exceeds %d bytes", int64(MaxGcodeBytes))
+			}
+			return writer.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/server/files/upload", pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	c.setAuth(req)
+
+	resp, err := c.uploadClient.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	respB, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(respB))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return fmt.Errorf("moonraker http %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// GetHistoryRaw fetches print job history from Moonraker as the untouched
+// response body; import_history forwards it verbatim.
+func (c *Client) GetHistoryRaw(ctx context.Context, limit int) (map[string]any, error) {
 	if limit <= 0 {
 		limit = 50 // Default limit
 	}
@@ -242,6 +717,7 @@ func (c *Client) GetHistory(ctx context.Context, limit int) (map[string]any, err
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
+	c.setAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -266,14 +742,97 @@ func (c *Client) GetHistory(ctx context.Context, limit int) (map[string]any, err
 	return out, nil
 }
 
-// DeleteFile deletes a file from Moonraker
-func (c *Client) DeleteFile(ctx context.Context, filename string) error {
-	u := c.baseURL + "/server/files/gcodes/" + url.PathEscape(filename)
+// HistoryJob is one entry of GetHistory. EndTime is nil for jobs still in
+// progress; FilamentUsed is in millimeters.
+type HistoryJob struct {
+	JobID         string     `json:"job_id"`
+	Filename      string     `json:"filename"`
+	Status        string     `json:"status"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time,omitempty"`
+	PrintDuration float64    `json:"print_duration"`
+	FilamentUsed  float64    `json:"filament_used"`
+}
+
+// GetHistory returns the most recent jobs, newest first.
+func (c *Client) GetHistory(ctx context.Context, limit int) ([]HistoryJob, error) {
+	if limit <= 0 {
+		limit = 50 // Default limit
+	}
+	var out struct {
+		Result struct {
+			Jobs []struct {
+				JobID         string   `json:"job_id"`
+				Filename      string   `json:"filename"`
+				Status        string   `json:"status"`
+				StartTime     float64  `json:"start_time"`
+				EndTime       *float64 `json:"end_time"`
+				PrintDuration float64  `json:"print_duration"`
+				FilamentUsed  float64  `json:"filament_used"`
+			} `json:"jobs"`
+		} `json:"result"`
+	}
+	path := fmt.Sprintf("/server/history/list?limit=%d&order=desc", limit)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]HistoryJob, 0, len(out.Result.Jobs))
+	for _, j := range out.Result.Jobs {
+		job := HistoryJob{
+			JobID:         j.JobID,
+			Filename:      j.Filename,
+			Status:        j.Status,
+			StartTime:     unixTime(j.StartTime),
+			PrintDuration: j.PrintDuration,
+			FilamentUsed:  j.FilamentUsed,
+		}
+		if j.EndTime != nil {
+			t := unixTime(*j.EndTime)
+			job.EndTime = &t
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// unixTime converts Moonraker's float seconds since the epoch.
+func unixTime(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second))).UTC()
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+// ValidateGcodePath checks that p is a relative path that stays inside the
+// gcodes root and returns it cleaned.
+func ValidateGcodePath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if strings.Contains(p, "\\") || strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("path %q must not contain ..", p)
+		}
+	}
+	clean := path.Clean(p)
+	if clean == "." {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return clean, nil
+}
+
+// DeleteGcode deletes a file (relative to the gcodes root) from Moonraker.
+func (c *Client) DeleteGcode(ctx context.Context, p string) error {
+	clean, err := ValidateGcodePath(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.gcodeFileURL(clean), nil)
 	if err != nil {
 		return err
 	}
+	c.setAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -296,9 +855,9 @@ func (c *Client) DeleteFile(ctx context.Context, filename string) error {
 
 // FileInfo represents a file from Moonraker
 type FileInfo struct {
-	Path         string  `json:"path"`
-	Modified     float64 `json:"modified"`
-	Size         int64   `json:"size"`
+	Path           string   `json:"path"`
+	Modified       float64  `json:"modified"`
+	Size           int64    `json:"size"`
 	PrintStartTime *float64 `json:"print_start_time,omitempty"`
 }
 
@@ -311,6 +870,7 @@ func (c *Client) ListFiles(ctx context.Context) ([]map[string]any, error) {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
+	c.setAuth(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -339,6 +899,110 @@ func (c *Client) ListFiles(ctx context.Context) ([]map[string]any, error) {
 	return response.Result, nil
 }
 
+// GcodeFile is one entry of ListGcodes.
+type GcodeFile struct {
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// ListGcodes returns the files in the gcodes root, newest first.
+func (c *Client) ListGcodes(ctx context.Context) ([]GcodeFile, error) {
+	u := c.baseURL + "/server/files/list?root=gcodes"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	c.setAuth(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Large libraries easily exceed the usual 1MB response cap.
+	respB, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(respB))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("moonraker http %d: %s", resp.StatusCode, msg)
+	}
+
+	var response struct {
+		Result []FileInfo `json:"result"`
+	}
+	if err := json.Unmarshal(respB, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	files := make([]GcodeFile, 0, len(response.Result))
+	for _, f := range response.Result {
+		files = append(files, GcodeFile{
+			Filename: f.Path,
+			Size:     f.Size,
+			Modified: unixTime(f.Modified),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Modified.After(files[j].Modified)
+	})
+	return files, nil
+}
+
+// MaxWebcamImageBytes caps the size of a frame fetched by CaptureWebcamSnapshot.
+const MaxWebcamImageBytes = 10 << 20 // 10MB
+
+// CaptureWebcamSnapshot fetches a single frame from webcamURL (e.g. a
+// crowsnest/ustreamer "?action=snapshot" URL). Responses that aren't images,
+// or are larger than MaxWebcamImageBytes, are rejected.
+func (c *Client) CaptureWebcamSnapshot(ctx context.Context, webcamURL string) ([]byte, error) {
+	webcamURL, err := c.withOneshotToken(ctx, webcamURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, webcamURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respB, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		msg := strings.TrimSpace(string(respB))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("webcam http %d: %s", resp.StatusCode, msg)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("webcam returned %s, not an image", ct)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxWebcamImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if len(data) > MaxWebcamImageBytes {
+		return nil, fmt.Errorf("webcam image exceeds %d bytes", MaxWebcamImageBytes)
+	}
+	if ct := http.DetectContentType(data); !strings.HasPrefix(ct, "image/") {
+		return nil, fmt.Errorf("webcam returned %s, not an image", ct)
+	}
+	return data, nil
+}
+
 // GetWebcamSnapshot retrieves a webcam snapshot from Moonraker
 // Returns the image bytes and content type, or an error
 func (c *Client) GetWebcamSnapshot(ctx context.Context) ([]byte, string, error) {
@@ -351,7 +1015,11 @@ func (c *Client) GetWebcamSnapshot(ctx context.Context) ([]byte, string, error)
 
 	var lastErr error
 	for _, endpoint := range endpoints {
-		u := c.uiBaseURL + endpoint
+		u, err := c.withOneshotToken(ctx, c.uiBaseURL+endpoint)
+		if err != nil {
+			lastErr = err
+			continue
+		}
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 		if err != nil {
 			lastErr = err
@@ -0,0 +1,138 @@
+package moonraker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractStatus(t *testing.T) {
+	status := map[string]any{"print_stats": map[string]any{"state": "printing"}}
+
+	tests := []struct {
+		name   string
+		resp   map[string]any
+		want   map[string]any
+		wantOK bool
+	}{
+		{
+			name:   "http",
+			resp:   map[string]any{"result": map[string]any{"eventtime": 12.5, "status": status}},
+			want:   status,
+			wantOK: true,
+		},
+		{
+			name:   "rpc response",
+			resp:   map[string]any{"jsonrpc": "2.0", "id": 7, "result": map[string]any{"eventtime": 12.5, "status": status}},
+			want:   status,
+			wantOK: true,
+		},
+		{
+			name:   "bare result",
+			resp:   map[string]any{"eventtime": 12.5, "status": status},
+			want:   status,
+			wantOK: true,
+		},
+		{
+			name:   "rpc notification",
+			resp:   map[string]any{"jsonrpc": "2.0", "method": "notify_status_update", "params": []any{status, 12.5}},
+			want:   status,
+			wantOK: true,
+		},
+		{
+			name: "empty params",
+			resp: map[string]any{"method": "notify_status_update", "params": []any{}},
+		},
+		{
+			name: "status not an object",
+			resp: map[string]any{"result": map[string]any{"status": "ok"}},
+		},
+		{
+			name: "unknown shape",
+			resp: map[string]any{"print_stats": map[string]any{"state": "printing"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ExtractStatus(tt.resp)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractStatus() = %v, %v; want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReplaceStatus(t *testing.T) {
+	old := map[string]any{"print_stats": map[string]any{"state": "printing"}, "toolhead": map[string]any{}}
+	status := map[string]any{"print_stats": map[string]any{"state": "printing"}}
+
+	tests := []struct {
+		name string
+		resp map[string]any
+		want map[string]any
+	}{
+		{
+			name: "http",
+			resp: map[string]any{"result": map[string]any{"eventtime": 12.5, "status": old}},
+			want: map[string]any{"result": map[string]any{"eventtime": 12.5, "status": status}},
+		},
+		{
+			name: "rpc response",
+			resp: map[string]any{"jsonrpc": "2.0", "id": 7, "result": map[string]any{"eventtime": 12.5, "status": old}},
+			want: map[string]any{"jsonrpc": "2.0", "id": 7, "result": map[string]any{"eventtime": 12.5, "status": status}},
+		},
+		{
+			name: "bare result",
+			resp: map[string]any{"eventtime": 12.5, "status": old},
+			want: map[string]any{"eventtime": 12.5, "status": status},
+		},
+		{
+			name: "rpc notification",
+			resp: map[string]any{"method": "notify_status_update", "params": []any{old, 12.5}},
+			want: map[string]any{"method": "notify_status_update", "params": []any{status, 12.5}},
+		},
+		{
+			name: "unknown shape",
+			resp: map[string]any{"foo": "bar"},
+			want: map[string]any{"foo": "bar"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := deepCopy(tt.resp)
+			got := ReplaceStatus(tt.resp, status)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReplaceStatus() = %v; want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.resp, before) {
+				t.Errorf("ReplaceStatus modified its input: %v", tt.resp)
+			}
+			// Whatever ReplaceStatus builds, ExtractStatus must read back.
+			if s, ok := ExtractStatus(got); ok && !reflect.DeepEqual(s, status) {
+				t.Errorf("ExtractStatus(ReplaceStatus()) = %v; want %v", s, status)
+			}
+		})
+	}
+}
+
+// deepCopy copies the maps and slices of a decoded JSON value.
+func deepCopy(v map[string]any) map[string]any {
+	out := make(map[string]any, len(v))
+	for k, e := range v {
+		out[k] = deepCopyValue(e)
+	}
+	return out
+}
+
+func deepCopyValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return deepCopy(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = deepCopyValue(e)
+		}
+		return out
+	}
+	return v
+}
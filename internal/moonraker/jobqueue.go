@@ -0,0 +1,55 @@
+package moonraker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// QueueItem is one job waiting in Moonraker's job queue.
+type QueueItem struct {
+	JobID     string    `json:"job_id"`
+	Filename  string    `json:"filename"`
+	TimeAdded time.Time `json:"time_added"`
+}
+
+// EnqueueJob appends filename (relative to the gcodes root) to the job queue.
+func (c *Client) EnqueueJob(ctx context.Context, filename string) error {
+	if filename == "" {
+		return fmt.Errorf("filename is required")
+	}
+	return c.doJSON(ctx, http.MethodPost, "/server/job_queue/job?filenames="+url.QueryEscape(filename), nil, nil)
+}
+
+// ListQueue returns the queued jobs in the order they will run.
+func (c *Client) ListQueue(ctx context.Context) ([]QueueItem, error) {
+	var out struct {
+		Result struct {
+			QueuedJobs []struct {
+				JobID     string  `json:"job_id"`
+				Filename  string  `json:"filename"`
+				TimeAdded float64 `json:"time_added"`
+			} `json:"queued_jobs"`
+		} `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/server/job_queue/status", nil, &out); err != nil {
+		return nil, err
+	}
+
+	items := make([]QueueItem, 0, len(out.Result.QueuedJobs))
+	for _, j := range out.Result.QueuedJobs {
+		items = append(items, QueueItem{
+			JobID:     j.JobID,
+			Filename:  j.Filename,
+			TimeAdded: unixTime(j.TimeAdded),
+		})
+	}
+	return items, nil
+}
+
+// ClearQueue removes every job from the queue.
+func (c *Client) ClearQueue(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodDelete, "/server/job_queue/job?all=true", nil, nil)
+}
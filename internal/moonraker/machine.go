@@ -0,0 +1,114 @@
+package moonraker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SystemInfo is the part of /machine/system_info used for asset tracking.
+type SystemInfo struct {
+	CPUModel      string `json:"cpu_model,omitempty"`
+	CPUCount      int    `json:"cpu_count,omitempty"`
+	TotalMemory   int64  `json:"total_memory,omitempty"`
+	MemoryUnits   string `json:"memory_units,omitempty"` // "kB" as reported
+	Distribution  string `json:"distribution,omitempty"` // e.g. "Debian GNU/Linux 11 (bullseye)"
+	PythonVersion string `json:"python_version,omitempty"`
+}
+
+// GetSystemInfo describes the Moonraker host: CPU, memory, OS distribution
+// and the Python running Moonraker.
+func (c *Client) GetSystemInfo(ctx context.Context) (*SystemInfo, error) {
+	var out struct {
+		Result struct {
+			SystemInfo struct {
+				CPUInfo struct {
+					CPUCount    int    `json:"cpu_count"`
+					Processor   string `json:"processor"`
+					CPUDesc     string `json:"cpu_desc"`
+					Model       string `json:"model"`
+					TotalMemory int64  `json:"total_memory"`
+					MemoryUnits string `json:"memory_units"`
+				} `json:"cpu_info"`
+				Distribution struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+				} `json:"distribution"`
+				Python struct {
+					VersionString string `json:"version_string"`
+				} `json:"python"`
+			} `json:"system_info"`
+		} `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/machine/system_info", nil, &out); err != nil {
+		return nil, err
+	}
+
+	si := out.Result.SystemInfo
+	// model names the board (e.g. "Raspberry Pi 4 Model B Rev 1.4") and
+	// cpu_desc the CPU; either may be empty, e.g. on x86 hosts.
+	cpu := si.CPUInfo.CPUDesc
+	if cpu == "" {
+		cpu = si.CPUInfo.Processor
+	}
+	if si.CPUInfo.Model != "" {
+		if cpu == "" {
+			cpu = si.CPUInfo.Model
+		} else {
+			cpu = si.CPUInfo.Model + " (" + cpu + ")"
+		}
+	}
+	distro := si.Distribution.Name
+	if si.Distribution.Version != "" && !strings.Contains(distro, si.Distribution.Version) {
+		distro = strings.TrimSpace(distro + " " + si.Distribution.Version)
+	}
+	// version_string is like "3.9.2 (default, Feb 28 2021, 17:03:44) [GCC 10.2.1]".
+	python, _, _ := strings.Cut(si.Python.VersionString, " ")
+
+	return &SystemInfo{
+		CPUModel:      cpu,
+		CPUCount:      si.CPUInfo.CPUCount,
+		TotalMemory:   si.CPUInfo.TotalMemory,
+		MemoryUnits:   si.CPUInfo.MemoryUnits,
+		Distribution:  distro,
+		PythonVersion: python,
+	}, nil
+}
+
+// PowerDevice is a Moonraker [power] device, e.g. a smart plug.
+type PowerDevice struct {
+	Device string `json:"device"`
+	Status string `json:"status"` // "on", "off", "init" or "error"
+	Type   string `json:"type"`
+
+	// LockedWhilePrinting devices refuse to be switched during a print.
+	LockedWhilePrinting bool `json:"locked_while_printing"`
+}
+
+// ListPowerDevices returns the configured power devices and their state.
+func (c *Client) ListPowerDevices(ctx context.Context) ([]PowerDevice, error) {
+	var out struct {
+		Result struct {
+			Devices []PowerDevice `json:"devices"`
+		} `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/machine/device_power/devices", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Result.Devices, nil
+}
+
+// SetPower switches a power device on or off.
+func (c *Client) SetPower(ctx context.Context, device string, on bool) error {
+	if device == "" {
+		return fmt.Errorf("device is required")
+	}
+	action := "off"
+	if on {
+		action = "on"
+	}
+	path := "/machine/device_power/device?device=" + url.QueryEscape(device) + "&action=" + action
+	return c.doJSON(ctx, http.MethodPost, path, nil, nil)
+}
@@ -0,0 +1,65 @@
+package moonraker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServerInfo is the part of /server/info the connector uses.
+type ServerInfo struct {
+	KlippyState      string `json:"klippy_state"`
+	MoonrakerVersion string `json:"moonraker_version"`
+	APIVersionString string `json:"api_version_string"`
+}
+
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	var out struct {
+		Result ServerInfo `json:"result"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/server/info", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Result, nil
+}
+
+// ParseVersion extracts major, minor and patch from a Moonraker version
+// string such as "v0.8.0-138-g4d2fb9c" (git describe output). A missing
+// patch is 0.
+func ParseVersion(s string) ([3]int, error) {
+	var v [3]int
+	core, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid moonraker version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid moonraker version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// VersionAtLeast reports whether version is min or newer. Both are parsed
+// with ParseVersion.
+func VersionAtLeast(version, min string) (bool, error) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	m, err := ParseVersion(min)
+	if err != nil {
+		return false, err
+	}
+	for i := range v {
+		if v[i] != m[i] {
+			return v[i] > m[i], nil
+		}
+	}
+	return true, nil
+}
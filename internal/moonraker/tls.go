@@ -0,0 +1,31 @@
+package moonraker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadTLSConfig returns the transport's TLS config for opts, or nil (Go
+// defaults, verified against the system roots) when no TLS option is set.
+func loadTLSConfig(opts Options) (*tls.Config, error) {
+	if opts.CACertPath == "" && !opts.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CACertPath != "" {
+		pem, err := os.ReadFile(opts.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read moonraker ca cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACertPath)
+		}
+		cfg.RootCAs = pool
+	}
+	cfg.InsecureSkipVerify = opts.InsecureSkipVerify
+	return cfg, nil
+}
@@ -0,0 +1,60 @@
+package moonraker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Clients that talk to the same Moonraker host share one transport, so
+// several printers behind one host (on different ports) share a connection
+// pool and idle-connection reaper instead of each holding their own.
+var (
+	transportsMu sync.Mutex
+	transports   = map[transportKey]*http.Transport{}
+)
+
+type transportKey struct {
+	scheme, host string
+	// Transports are only shared between clients with identical settings.
+	dial, tls, header time.Duration
+	caCertPath        string
+	insecure          bool
+}
+
+func sharedTransport(baseURL string, dial, tlsHandshake, header time.Duration, opts Options, tlsConfig *tls.Config) *http.Transport {
+	key := transportKey{dial: dial, tls: tlsHandshake, header: header, caCertPath: opts.CACertPath, insecure: opts.InsecureSkipVerify}
+	if u, err := url.Parse(baseURL); err == nil {
+		key.scheme, key.host = u.Scheme, u.Hostname()
+	} else {
+		key.host = baseURL
+	}
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if t, ok := transports[key]; ok {
+		return t
+	}
+	t := &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: dial}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   tlsHandshake,
+		ResponseHeaderTimeout: header,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	transports[key] = t
+	return t
+}
+
+// CloseIdleConnections closes idle connections on every shared transport.
+// Call it on shutdown so keep-alive sockets to Moonraker aren't left open.
+func CloseIdleConnections() {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,339 @@
+package moonraker
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 client, just enough for Moonraker's JSON-RPC socket.
+// The connector is stdlib-only, so this avoids pulling in a websocket module.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	// wsMaxMessage bounds a single reassembled message.
+	wsMaxMessage = 4 << 20
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// dialWebsocket opens a websocket to path on the Moonraker host.
+func (c *Client) dialWebsocket(ctx context.Context, path string) (*wsConn, error) {
+	u, err := url.Parse(c.baseURL + path)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		// Same trust settings as the HTTP transport.
+		cfg := &tls.Config{}
+		if c.tlsConfig != nil {
+			cfg = c.tlsConfig.Clone()
+		}
+		cfg.ServerName = u.Hostname()
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Bound the handshake; cleared once the upgrade succeeds.
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	c.setAuth(req)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("moonraker websocket upgrade failed: http %d", resp.StatusCode)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, errors.New("moonraker websocket upgrade failed: bad Sec-WebSocket-Accept")
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, br: br}, nil
+}
+
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
+
+// writeFrame sends a single masked frame, as required for client frames.
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, 0x80|byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 0x80|126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, 0x80|127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	hdr = append(hdr, mask[:]...)
+
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	if _, err := ws.conn.Write(append(hdr, masked...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ws *wsConn) writeJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(wsOpText, b)
+}
+
+// readMessage returns the next complete data message, transparently
+// answering pings and reassembling fragmented messages.
+func (ws *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+			return nil, err
+		}
+		fin := hdr[0]&0x80 != 0
+		op := hdr[0] & 0x0F
+		masked := hdr[1]&0x80 != 0
+
+		n := uint64(hdr[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > wsMaxMessage || uint64(len(msg))+n > wsMaxMessage {
+			return nil, fmt.Errorf("moonraker websocket message exceeds %d bytes", wsMaxMessage)
+		}
+
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.br, payload); err != nil {
+			return nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch op {
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			_ = ws.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("moonraker websocket: unexpected opcode %d", op)
+		}
+	}
+}
+
+// Subscribe connects to Moonraker's JSON-RPC websocket and subscribes to the
+// given printer objects (nil field list = all fields). The first value sent on
+// the returned channel is the full initial status; subsequent values are the
+// deltas from notify_status_update. The channel is closed when the socket
+// drops or ctx is cancelled.
+func (c *Client) Subscribe(ctx context.Context, objects map[string][]string) (<-chan map[string]any, error) {
+	ws, err := c.dialWebsocket(ctx, "/websocket")
+	if err != nil {
+		return nil, err
+	}
+
+	const subscribeID = 1
+	if err := ws.writeJSON(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "printer.objects.subscribe",
+		"params":  map[string]any{"objects": objects},
+		"id":      subscribeID,
+	}); err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	ch := make(chan map[string]any, 16)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		ws.Close()
+	}()
+
+	go func() {
+		defer close(ch)
+		defer close(stop)
+
+		for {
+			msg, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+
+			var rpc struct {
+				ID     *int            `json:"id"`
+				Method string          `json:"method"`
+				Params []any           `json:"params"`
+				Result json.RawMessage `json:"result"`
+				Error  *struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(msg, &rpc); err != nil {
+				continue
+			}
+
+			var status map[string]any
+			switch {
+			case rpc.ID != nil && *rpc.ID == subscribeID:
+				if rpc.Error != nil {
+					return
+				}
+				var res struct {
+					Status map[string]any `json:"status"`
+				}
+				if err := json.Unmarshal(rpc.Result, &res); err != nil {
+					return
+				}
+				status = res.Status
+			case rpc.Method == "notify_status_update" && len(rpc.Params) > 0:
+				status, _ = rpc.Params[0].(map[string]any)
+			}
+			if status == nil {
+				continue
+			}
+
+			select {
+			case ch <- status:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// DefaultSnapshotObjects returns the printer objects included in snapshots,
+// in Moonraker's subscribe format (nil = all fields).
+func DefaultSnapshotObjects() map[string][]string {
+	return map[string][]string{
+		"print_stats":    nil,
+		"virtual_sdcard": nil,
+		"extruder":       nil,
+		"heater_bed":     nil,
+		"toolhead":       nil,
+		"pause_resume":   nil,
+	}
+}
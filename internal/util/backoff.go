@@ -6,20 +6,55 @@ import (
 )
 
 type Backoff struct {
-	min time.Duration
-	max time.Duration
-	cur time.Duration
+	min    time.Duration
+	max    time.Duration
+	cur    time.Duration
+	jitter float64
+
+	// MaxElapsed, when > 0, bounds the total of the waits handed out since
+	// the last Reset; Next reports false once it is used up.
+	MaxElapsed time.Duration
+
+	elapsed  time.Duration
+	attempts int
 }
 
 func NewBackoff(min, max time.Duration) *Backoff {
-	return &Backoff{min: min, max: max, cur: min}
+	return NewBackoffWithJitter(min, max, 0.25)
+}
+
+// NewBackoffWithJitter is like NewBackoff but randomizes each wait by
+// ±jitter (0.25 means 0.75–1.25x). jitter is clamped to [0, 1].
+func NewBackoffWithJitter(min, max time.Duration, jitter float64) *Backoff {
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return &Backoff{min: min, max: max, cur: min, jitter: jitter}
 }
 
 func (b *Backoff) Reset() {
 	b.cur = b.min
+	b.elapsed = 0
+	b.attempts = 0
 }
 
-func (b *Backoff) Next() time.Duration {
+// Attempt returns how many times Next has been called since the last Reset.
+func (b *Backoff) Attempt() int {
+	return b.attempts
+}
+
+// Next returns the next wait. ok is false, and the wait 0, once MaxElapsed
+// is used up: the caller should give up rather than retry. Without
+// MaxElapsed ok is always true.
+func (b *Backoff) Next() (wait time.Duration, ok bool) {
+	if b.MaxElapsed > 0 && b.elapsed >= b.MaxElapsed {
+		return 0, false
+	}
+	b.attempts++
+
 	d := b.cur
 	if b.cur < b.max {
 		b.cur *= 2
@@ -27,6 +62,13 @@ func (b *Backoff) Next() time.Duration {
 			b.cur = b.max
 		}
 	}
-	j := 0.75 + rand.Float64()*0.5
-	return time.Duration(float64(d) * j)
+	j := 1 - b.jitter + rand.Float64()*2*b.jitter
+	d = time.Duration(float64(d) * j)
+
+	// Never hand out more than what's left of the budget.
+	if b.MaxElapsed > 0 && b.elapsed+d > b.MaxElapsed {
+		d = b.MaxElapsed - b.elapsed
+	}
+	b.elapsed += d
+	return d, true
 }
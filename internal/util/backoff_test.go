@@ -0,0 +1,92 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowth(t *testing.T) {
+	b := NewBackoffWithJitter(time.Second, 8*time.Second, 0)
+	want := []time.Duration{1, 2, 4, 8, 8, 8}
+	for i, w := range want {
+		got, ok := b.Next()
+		if !ok || got != w*time.Second {
+			t.Fatalf("Next() #%d = %s, %v; want %s, true", i+1, got, ok, w*time.Second)
+		}
+	}
+
+	b.Reset()
+	if got, _ := b.Next(); got != time.Second {
+		t.Errorf("Next() after Reset = %s; want 1s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := NewBackoff(time.Second, time.Second)
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		got, ok := b.Next()
+		if !ok || got < 750*time.Millisecond || got > 1250*time.Millisecond {
+			t.Fatalf("Next() = %s, %v; want within 0.75–1.25s", got, ok)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Next() never varied; want jitter")
+	}
+
+	// Jitter is clamped to [0, 1].
+	for _, j := range []float64{-1, 0} {
+		if got, _ := NewBackoffWithJitter(time.Second, time.Second, j).Next(); got != time.Second {
+			t.Errorf("jitter %v: Next() = %s; want exactly 1s", j, got)
+		}
+	}
+	b = NewBackoffWithJitter(time.Second, time.Second, 5)
+	for i := 0; i < 200; i++ {
+		if got, _ := b.Next(); got < 0 || got > 2*time.Second {
+			t.Fatalf("jitter 5: Next() = %s; want within 0–2s", got)
+		}
+	}
+}
+
+func TestBackoffAttempt(t *testing.T) {
+	b := NewBackoff(time.Millisecond, time.Second)
+	if n := b.Attempt(); n != 0 {
+		t.Errorf("Attempt() before Next = %d; want 0", n)
+	}
+	b.Next()
+	b.Next()
+	b.Next()
+	if n := b.Attempt(); n != 3 {
+		t.Errorf("Attempt() = %d; want 3", n)
+	}
+	b.Reset()
+	if n := b.Attempt(); n != 0 {
+		t.Errorf("Attempt() after Reset = %d; want 0", n)
+	}
+}
+
+func TestBackoffMaxElapsed(t *testing.T) {
+	b := NewBackoffWithJitter(time.Second, time.Minute, 0)
+	b.MaxElapsed = 5 * time.Second
+
+	// 1s + 2s, then the 4s wait is cut to the 2s left.
+	want := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second}
+	for i, w := range want {
+		got, ok := b.Next()
+		if !ok || got != w {
+			t.Fatalf("Next() #%d = %s, %v; want %s, true", i+1, got, ok, w)
+		}
+	}
+	if got, ok := b.Next(); ok || got != 0 {
+		t.Errorf("Next() past MaxElapsed = %s, %v; want 0, false", got, ok)
+	}
+	if n := b.Attempt(); n != 3 {
+		t.Errorf("Attempt() = %d; want 3 (the refused call doesn't count)", n)
+	}
+
+	b.Reset()
+	if got, ok := b.Next(); !ok || got != time.Second {
+		t.Errorf("Next() after Reset = %s, %v; want 1s, true", got, ok)
+	}
+}
@@ -0,0 +1,14 @@
+package util
+
+import "syscall"
+
+// DiskSpace returns the bytes available to unprivileged users and the total
+// size of the filesystem holding path.
+func DiskSpace(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return st.Bavail * bsize, st.Blocks * bsize, nil
+}
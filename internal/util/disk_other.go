@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "errors"
+
+// DiskSpace is only implemented on Linux, the platform the connector ships for.
+func DiskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}